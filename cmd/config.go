@@ -0,0 +1,317 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "encoding/json"
+    "errors"
+    "github.com/kamaboko123/tanita_to_fitbit/csv_source"
+    "github.com/kamaboko123/tanita_to_fitbit/influxdb"
+    "github.com/kamaboko123/tanita_to_fitbit/webhook"
+    "github.com/kamaboko123/tanita_to_fitbit/mqtt"
+)
+
+const config_file = "config.json"
+const default_profile_name = "default"
+const default_health_planet_url = "https://www.healthplanet.jp"
+const default_fitbit_url = "https://api.fitbit.com"
+const default_withings_url = "https://wbsapi.withings.net"
+const default_withings_auth_url = "https://account.withings.com"
+
+const (
+    source_health_planet = "health_planet"
+    source_csv = "csv"
+    source_fitbit = "fitbit"
+)
+
+var support_sources = []string{source_health_planet, source_csv, source_fitbit}
+
+const (
+    sink_fitbit = "fitbit"
+    sink_influxdb = "influxdb"
+    sink_webhook = "webhook"
+    sink_mqtt = "mqtt"
+    sink_withings = "withings"
+)
+
+var support_sinks = []string{sink_fitbit, sink_influxdb, sink_webhook, sink_mqtt, sink_withings}
+
+type healthPlanetConfig struct {
+    ClientId string `json:"client_id"`
+    ClientSecret string `json:"client_secret"`
+    Timezone string `json:"timezone"`
+    TokenFile string `json:"token_file"`
+    Url string `json:"url"`
+}
+
+type fitbitConfig struct {
+    ClientId string `json:"client_id"`
+    ClientSecret string `json:"client_secret"`
+    Timezone string `json:"timezone"`
+    TokenFile string `json:"token_file"`
+    Url string `json:"url"`
+}
+
+type withingsConfig struct {
+    ClientId string `json:"client_id"`
+    ClientSecret string `json:"client_secret"`
+    Timezone string `json:"timezone"`
+    TokenFile string `json:"token_file"`
+    Url string `json:"url"`
+    AuthUrl string `json:"auth_url"`
+}
+
+// 同期の動作に関する設定
+// プロファイルに設定した場合はトップレベルの設定を丸ごと置き換える
+type syncConfig struct {
+    // HealthPlanetの歩数計データをFitbitのアクティビティ(Walk)として記録する
+    Pedometer bool `json:"pedometer"`
+
+    // 同期する体組成計の機種 (HealthPlanetのmodel)
+    // include_modelsが空の場合は全ての機種、exclude_modelsに含まれる機種は除外する
+    IncludeModels []string `json:"include_models"`
+    ExcludeModels []string `json:"exclude_models"`
+
+    // 送信先の記録と同じ測定とみなす日時の差(秒)と体重の差(kg)
+    // 省略時は120秒と0.1kg、match_windowが負の場合は日時が完全に一致する場合だけ
+    MatchWindow int `json:"match_window"`
+    MatchWeightTolerance float64 `json:"match_weight_tolerance"`
+
+    // Fitbitに他のアプリ経由で記録されたデータとの重複の扱い (always / same_day_weight / no_api_entry)
+    // same_day_weightは同じ日にfitbit_duplicate_weight_tolerance(kg、省略時は0.2)以内の記録があれば送信しない
+    FitbitDuplicatePolicy string `json:"fitbit_duplicate_policy"`
+    FitbitDuplicateWeightTolerance float64 `json:"fitbit_duplicate_weight_tolerance"`
+
+    // 1日に複数回測定した場合に送信するデータ (all / first / last / morning / average)
+    // morningはmorning_start〜morning_end(省略時は04:00〜10:00)の最初の測定
+    Daily string `json:"daily"`
+    MorningStart string `json:"morning_start"`
+    MorningEnd string `json:"morning_end"`
+
+    // 外れ値・家族や来客の測定の確認 (省略時は確認しない)
+    Filter *filterConfig `json:"filter"`
+}
+
+func (sc *syncConfig) IsModelTarget(model string) bool {
+    if contains(sc.ExcludeModels, model) {
+        return false
+    }
+    if len(sc.IncludeModels) == 0 {
+        return true
+    }
+    return contains(sc.IncludeModels, model)
+}
+
+type profile struct {
+    Name string `json:"name"`
+    HealthPlanet healthPlanetConfig `json:"health_planet"`
+    Fitbit fitbitConfig `json:"fitbit"`
+    Withings withingsConfig `json:"withings"`
+    Sync *syncConfig `json:"sync"`
+    StateFile string `json:"state_file"`
+    // 確認で弾かれた測定を保存するファイル
+    QuarantineFile string `json:"quarantine_file"`
+    // 測定データの取得元 (health_planet / csv / fitbit)
+    Source string `json:"source"`
+    CSV *csv_source.Config `json:"csv"`
+    // 測定データの送信先 (省略時はfitbitのみ)
+    Sinks []string `json:"sinks"`
+    InfluxDB *influxdb.Config `json:"influxdb"`
+    Webhook *webhook.Config `json:"webhook"`
+    MQTT *mqtt.Config `json:"mqtt"`
+}
+
+type config struct {
+    TokenDir string `json:"token_dir"`
+    HealthPlanet healthPlanetConfig `json:"health_planet"`
+    Fitbit fitbitConfig `json:"fitbit"`
+    Withings withingsConfig `json:"withings"`
+    Sync *syncConfig `json:"sync"`
+    StateFile string `json:"state_file"`
+    QuarantineFile string `json:"quarantine_file"`
+    Source string `json:"source"`
+    CSV *csv_source.Config `json:"csv"`
+    Sinks []string `json:"sinks"`
+    InfluxDB *influxdb.Config `json:"influxdb"`
+    Webhook *webhook.Config `json:"webhook"`
+    MQTT *mqtt.Config `json:"mqtt"`
+    Profiles []profile `json:"profiles"`
+}
+
+func load_config(path string) (*config, error) {
+    var c config
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    err = json.Unmarshal(data, &c)
+    if err != nil {
+        return nil, err
+    }
+    err = apply_env_overrides(&c)
+    if err != nil {
+        return nil, err
+    }
+    if c.TokenDir == "" {
+        c.TokenDir = default_token_dir(path)
+    }
+    return &c, nil
+}
+
+func default_string(value string, def string) string {
+    if value == "" {
+        return def
+    }
+    return value
+}
+
+// 未設定の項目はトップレベルの設定を引き継ぐ
+// (同じアプリケーションを家族で共有する場合はトークンだけ分ければよい)
+func (c *config) resolve_profile(p profile) profile {
+    p.HealthPlanet.ClientId = default_string(p.HealthPlanet.ClientId, c.HealthPlanet.ClientId)
+    p.HealthPlanet.ClientSecret = default_string(p.HealthPlanet.ClientSecret, c.HealthPlanet.ClientSecret)
+    p.HealthPlanet.Timezone = default_string(p.HealthPlanet.Timezone, c.HealthPlanet.Timezone)
+    p.HealthPlanet.TokenFile = token_path(c.TokenDir, default_string(p.HealthPlanet.TokenFile, fmt.Sprintf("hp_token_%s.json", p.Name)))
+    p.HealthPlanet.Url = default_string(p.HealthPlanet.Url, default_string(c.HealthPlanet.Url, default_health_planet_url))
+
+    p.Fitbit.ClientId = default_string(p.Fitbit.ClientId, c.Fitbit.ClientId)
+    p.Fitbit.ClientSecret = default_string(p.Fitbit.ClientSecret, c.Fitbit.ClientSecret)
+    p.Fitbit.Timezone = default_string(p.Fitbit.Timezone, c.Fitbit.Timezone)
+    p.Fitbit.TokenFile = token_path(c.TokenDir, default_string(p.Fitbit.TokenFile, fmt.Sprintf("fb_token_%s.json", p.Name)))
+    p.Fitbit.Url = default_string(p.Fitbit.Url, default_string(c.Fitbit.Url, default_fitbit_url))
+
+    p.Withings.ClientId = default_string(p.Withings.ClientId, c.Withings.ClientId)
+    p.Withings.ClientSecret = default_string(p.Withings.ClientSecret, c.Withings.ClientSecret)
+    p.Withings.Timezone = default_string(p.Withings.Timezone, c.Withings.Timezone)
+    p.Withings.TokenFile = token_path(c.TokenDir, default_string(p.Withings.TokenFile, fmt.Sprintf("wi_token_%s.json", p.Name)))
+    p.Withings.Url = default_string(p.Withings.Url, default_string(c.Withings.Url, default_withings_url))
+    p.Withings.AuthUrl = default_string(p.Withings.AuthUrl, default_string(c.Withings.AuthUrl, default_withings_auth_url))
+
+    p.StateFile = token_path(c.TokenDir, default_string(p.StateFile, fmt.Sprintf("state_%s.json", p.Name)))
+    p.QuarantineFile = token_path(c.TokenDir, default_string(p.QuarantineFile, fmt.Sprintf("quarantine_%s.json", p.Name)))
+
+    if p.Sync == nil {
+        p.Sync = c.default_sync()
+    }
+    p.Source = default_string(p.Source, default_string(c.Source, source_health_planet))
+    if p.CSV == nil {
+        p.CSV = c.CSV
+    }
+    if len(p.Sinks) == 0 {
+        p.Sinks = c.default_sinks()
+    }
+    if p.InfluxDB == nil {
+        p.InfluxDB = c.InfluxDB
+    }
+    p.Webhook = c.resolve_webhook(p.Webhook, fmt.Sprintf("webhook_%s.json", p.Name))
+    p.MQTT = c.resolve_mqtt(p.MQTT, fmt.Sprintf("mqtt_%s.json", p.Name))
+
+    return p
+}
+
+func (c *config) default_sync() *syncConfig {
+    if c.Sync == nil {
+        return &syncConfig{}
+    }
+    sc := *c.Sync
+    return &sc
+}
+
+func (c *config) default_sinks() []string {
+    if len(c.Sinks) == 0 {
+        return []string{sink_fitbit}
+    }
+    return c.Sinks
+}
+
+// 送信履歴はプロファイルごとに分けるので、トップレベルの設定はコピーしてから使う
+func (c *config) resolve_webhook(w *webhook.Config, status_file string) *webhook.Config {
+    if w == nil {
+        if c.Webhook == nil {
+            return nil
+        }
+        w = c.Webhook
+    }
+    wc := *w
+    wc.StatusFile = token_path(c.TokenDir, default_string(wc.StatusFile, status_file))
+    return &wc
+}
+
+func (c *config) resolve_mqtt(m *mqtt.Config, status_file string) *mqtt.Config {
+    if m == nil {
+        if c.MQTT == nil {
+            return nil
+        }
+        m = c.MQTT
+    }
+    mc := *m
+    mc.StatusFile = token_path(c.TokenDir, default_string(mc.StatusFile, status_file))
+    return &mc
+}
+
+func (p *profile) HasSink(name string) bool {
+    return contains(p.Sinks, name)
+}
+
+// profilesが無い場合はトップレベルの設定を"default"プロファイルとして扱う
+func (c *config) GetProfiles() ([]profile, error) {
+    if len(c.Profiles) == 0 {
+        p := profile{
+            Name: default_profile_name,
+            HealthPlanet: c.HealthPlanet,
+            Fitbit: c.Fitbit,
+            Withings: c.Withings,
+            Sync: c.default_sync(),
+            StateFile: token_path(c.TokenDir, default_string(c.StateFile, "state.json")),
+            QuarantineFile: token_path(c.TokenDir, default_string(c.QuarantineFile, "quarantine.json")),
+            Source: default_string(c.Source, source_health_planet),
+            CSV: c.CSV,
+            Sinks: c.default_sinks(),
+            InfluxDB: c.InfluxDB,
+            Webhook: c.resolve_webhook(nil, "webhook.json"),
+            MQTT: c.resolve_mqtt(nil, "mqtt.json"),
+        }
+        p.HealthPlanet.TokenFile = token_path(c.TokenDir, default_string(p.HealthPlanet.TokenFile, "hp_token.json"))
+        p.Fitbit.TokenFile = token_path(c.TokenDir, default_string(p.Fitbit.TokenFile, "fb_token.json"))
+        p.Withings.TokenFile = token_path(c.TokenDir, default_string(p.Withings.TokenFile, "wi_token.json"))
+        p.HealthPlanet.Url = default_string(p.HealthPlanet.Url, default_health_planet_url)
+        p.Fitbit.Url = default_string(p.Fitbit.Url, default_fitbit_url)
+        p.Withings.Url = default_string(p.Withings.Url, default_withings_url)
+        p.Withings.AuthUrl = default_string(p.Withings.AuthUrl, default_withings_auth_url)
+        return []profile{p}, nil
+    }
+
+    var profiles []profile
+    names := make(map[string]bool)
+    for _, p := range c.Profiles {
+        if p.Name == "" {
+            return nil, errors.New("Profile name is empty")
+        }
+        if names[p.Name] {
+            return nil, errors.New(fmt.Sprintf("Profile name is duplicated: %s", p.Name))
+        }
+        names[p.Name] = true
+        profiles = append(profiles, c.resolve_profile(p))
+    }
+
+    return profiles, nil
+}
+
+// nameが空の場合は全てのプロファイルを返す
+func (c *config) SelectProfiles(name string) ([]profile, error) {
+    profiles, err := c.GetProfiles()
+    if err != nil {
+        return nil, err
+    }
+    if name == "" {
+        return profiles, nil
+    }
+
+    for _, p := range profiles {
+        if p.Name == name {
+            return []profile{p}, nil
+        }
+    }
+
+    return nil, errors.New(fmt.Sprintf("Profile not found: %s", name))
+}
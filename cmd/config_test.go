@@ -0,0 +1,151 @@
+package main
+
+import (
+    "testing"
+)
+
+func TestGetProfilesDefault(t *testing.T) {
+    c := &config{
+        TokenDir: "/var/lib/ttf",
+        Fitbit: fitbitConfig{ClientId: "fb", Timezone: "Asia/Tokyo", TokenFile: "/secrets/fb.json"},
+        Withings: withingsConfig{Url: "http://withings.local"},
+    }
+    profiles, err := c.GetProfiles()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(profiles) != 1 {
+        t.Fatalf("profiles = %d", len(profiles))
+    }
+    p := profiles[0]
+
+    // profilesが無い場合は"default"として、トークンは接尾辞の無いファイル名
+    tests := []struct {
+        name string
+        got string
+        want string
+    }{
+        {"name", p.Name, default_profile_name},
+        {"health_planet.token_file", p.HealthPlanet.TokenFile, "/var/lib/ttf/hp_token.json"},
+        {"fitbit.token_file", p.Fitbit.TokenFile, "/secrets/fb.json"},
+        {"withings.token_file", p.Withings.TokenFile, "/var/lib/ttf/wi_token.json"},
+        {"state_file", p.StateFile, "/var/lib/ttf/state.json"},
+        {"quarantine_file", p.QuarantineFile, "/var/lib/ttf/quarantine.json"},
+        {"health_planet.url", p.HealthPlanet.Url, default_health_planet_url},
+        {"fitbit.url", p.Fitbit.Url, default_fitbit_url},
+        {"withings.url", p.Withings.Url, "http://withings.local"},
+        {"withings.auth_url", p.Withings.AuthUrl, default_withings_auth_url},
+        {"source", p.Source, source_health_planet},
+    }
+    for _, tt := range tests {
+        if tt.got != tt.want {
+            t.Errorf("%s = %q, want %q", tt.name, tt.got, tt.want)
+        }
+    }
+    if len(p.Sinks) != 1 || p.Sinks[0] != sink_fitbit || p.Sync == nil {
+        t.Errorf("sinks = %v, sync = %v", p.Sinks, p.Sync)
+    }
+}
+
+func TestGetProfilesInherit(t *testing.T) {
+    c := &config{
+        TokenDir: "/data",
+        HealthPlanet: healthPlanetConfig{ClientId: "hp_id", ClientSecret: "hp_secret", Timezone: "Asia/Tokyo"},
+        Fitbit: fitbitConfig{ClientId: "fb_id", ClientSecret: "fb_secret", Timezone: "Asia/Tokyo", Url: "http://fitbit.local"},
+        Sync: &syncConfig{Daily: daily_first},
+        Source: source_csv,
+        Sinks: []string{sink_fitbit, sink_webhook},
+        Profiles: []profile{
+            {Name: "alice"},
+            {
+                Name: "bob",
+                Fitbit: fitbitConfig{Timezone: "America/Los_Angeles", TokenFile: "bob_fitbit.json"},
+                Sync: &syncConfig{Daily: daily_last},
+                Sinks: []string{sink_influxdb},
+            },
+        },
+    }
+    profiles, err := c.GetProfiles()
+    if err != nil {
+        t.Fatal(err)
+    }
+    alice, bob := profiles[0], profiles[1]
+
+    tests := []struct {
+        name string
+        got string
+        want string
+    }{
+        // 未設定の項目はトップレベルを引き継ぐ
+        {"alice health_planet.client_id", alice.HealthPlanet.ClientId, "hp_id"},
+        {"alice fitbit.client_secret", alice.Fitbit.ClientSecret, "fb_secret"},
+        {"alice fitbit.timezone", alice.Fitbit.Timezone, "Asia/Tokyo"},
+        {"alice fitbit.url", alice.Fitbit.Url, "http://fitbit.local"},
+        {"alice health_planet.url", alice.HealthPlanet.Url, default_health_planet_url},
+        {"alice source", alice.Source, source_csv},
+        {"alice sync.daily", alice.Sync.Daily, daily_first},
+        // トークンなどはプロファイルごとのファイル
+        {"alice health_planet.token_file", alice.HealthPlanet.TokenFile, "/data/hp_token_alice.json"},
+        {"alice fitbit.token_file", alice.Fitbit.TokenFile, "/data/fb_token_alice.json"},
+        {"alice withings.token_file", alice.Withings.TokenFile, "/data/wi_token_alice.json"},
+        {"alice state_file", alice.StateFile, "/data/state_alice.json"},
+        {"alice quarantine_file", alice.QuarantineFile, "/data/quarantine_alice.json"},
+        // プロファイルの設定が優先
+        {"bob fitbit.client_id", bob.Fitbit.ClientId, "fb_id"},
+        {"bob fitbit.timezone", bob.Fitbit.Timezone, "America/Los_Angeles"},
+        {"bob fitbit.token_file", bob.Fitbit.TokenFile, "/data/bob_fitbit.json"},
+        {"bob sync.daily", bob.Sync.Daily, daily_last},
+    }
+    for _, tt := range tests {
+        if tt.got != tt.want {
+            t.Errorf("%s = %q, want %q", tt.name, tt.got, tt.want)
+        }
+    }
+    if len(alice.Sinks) != 2 || len(bob.Sinks) != 1 || bob.Sinks[0] != sink_influxdb {
+        t.Errorf("sinks: alice = %v, bob = %v", alice.Sinks, bob.Sinks)
+    }
+    // syncはコピーするので、プロファイルの変更はトップレベルに影響しない
+    alice.Sync.Daily = daily_all
+    if c.Sync.Daily != daily_first {
+        t.Errorf("top-level sync changed")
+    }
+}
+
+func TestSelectProfiles(t *testing.T) {
+    tests := []struct {
+        name string
+        profiles []profile
+        select_name string
+        want []string
+        fail bool
+    }{
+        {"all", []profile{{Name: "alice"}, {Name: "bob"}}, "", []string{"alice", "bob"}, false},
+        {"one", []profile{{Name: "alice"}, {Name: "bob"}}, "bob", []string{"bob"}, false},
+        {"unknown", []profile{{Name: "alice"}}, "carol", nil, true},
+        {"default", nil, "default", []string{"default"}, false},
+        {"unknown without profiles", nil, "alice", nil, true},
+        {"empty name", []profile{{Name: ""}}, "", nil, true},
+        {"duplicated", []profile{{Name: "alice"}, {Name: "alice"}}, "", nil, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            c := &config{TokenDir: "/data", Profiles: tt.profiles}
+            got, err := c.SelectProfiles(tt.select_name)
+            if (err != nil) != tt.fail {
+                t.Fatalf("err = %v", err)
+            }
+            var names []string
+            for _, p := range got {
+                names = append(names, p.Name)
+            }
+            if len(names) != len(tt.want) {
+                t.Fatalf("profiles = %v, want %v", names, tt.want)
+            }
+            for i := range names {
+                if names[i] != tt.want[i] {
+                    t.Errorf("profiles = %v, want %v", names, tt.want)
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+    "fmt"
+    "math"
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+// 1日に複数回測定した場合に送信するデータの選び方
+const (
+    // 全て送信する
+    daily_all = "all"
+    // その日の最初の測定
+    daily_first = "first"
+    // その日の最後の測定 (その日が終わってから送信する)
+    daily_last = "last"
+    // morning_start〜morning_endの間の最初の測定
+    daily_morning = "morning"
+    // その日の平均 (その日が終わってから、最初の測定の日時で送信する)
+    daily_average = "average"
+)
+
+var support_daily_policies = []string{daily_all, daily_first, daily_last, daily_morning, daily_average}
+
+const default_morning_start = "04:00"
+const default_morning_end = "10:00"
+
+func (sc *syncConfig) daily_policy() string {
+    return default_string(sc.Daily, daily_all)
+}
+
+// "15:04"形式の時刻を0時からの経過時間にする
+func parse_clock(value string) (time.Duration, error) {
+    t, err := time.Parse("15:04", value)
+    if err != nil {
+        return 0, err
+    }
+    return time.Duration(t.Hour()) * time.Hour + time.Duration(t.Minute()) * time.Minute, nil
+}
+
+func (sc *syncConfig) morning_window() (time.Duration, time.Duration) {
+    start, _ := parse_clock(default_string(sc.MorningStart, default_morning_start))
+    end, _ := parse_clock(default_string(sc.MorningEnd, default_morning_end))
+    return start, end
+}
+
+func day_key(t time.Time) string {
+    return t.Format("2006-01-02")
+}
+
+// tと同じ日(tのタイムゾーン)の0時
+func start_of_day(t time.Time) time.Time {
+    return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func since_midnight(t time.Time) time.Duration {
+    return t.Sub(start_of_day(t))
+}
+
+// 測定データを日付(測定日時のタイムゾーン)ごとにまとめ、方針に従って1日分のデータを選ぶ
+// 集計が変わらないように、最後の測定・平均はその日が終わるまで送信しない
+// dataにはそれぞれの日の測定が全て含まれている必要がある (Syncr.fetchでその日の0時から取得する)
+// 2つ目の戻り値は、選び終えた日の測定のうち選ばなかった測定 (平均の場合は平均と日時・機種が違う測定)
+func (sc *syncConfig) aggregate(data []measurement.BodyMeasurement, now time.Time) ([]measurement.BodyMeasurement, []measurement.BodyMeasurement) {
+    policy := sc.daily_policy()
+    if policy == daily_all {
+        return data, nil
+    }
+
+    sorted := append([]measurement.BodyMeasurement{}, data...)
+    measurement.SortByDate(sorted)
+
+    var days []string
+    by_day := make(map[string][]measurement.BodyMeasurement)
+    for _, m := range sorted {
+        key := day_key(m.Date)
+        if _, ok := by_day[key]; !ok {
+            days = append(days, key)
+        }
+        by_day[key] = append(by_day[key], m)
+    }
+
+    var ret []measurement.BodyMeasurement
+    var unselected []measurement.BodyMeasurement
+    for _, key := range days {
+        ms := by_day[key]
+        today := key == day_key(now.In(ms[0].Date.Location()))
+
+        var selected *measurement.BodyMeasurement
+        switch policy {
+        case daily_first:
+            selected = &ms[0]
+        case daily_last:
+            if today {
+                Logger.Debug(fmt.Sprintf("Skip %s until the day is over (%s)", key, policy))
+                continue
+            }
+            selected = &ms[len(ms) - 1]
+        case daily_morning:
+            start, end := sc.morning_window()
+            for i := range ms {
+                d := since_midnight(ms[i].Date)
+                if d >= start && d < end {
+                    selected = &ms[i]
+                    break
+                }
+            }
+            if selected == nil {
+                Logger.Debug(fmt.Sprintf("Skip %s, no measurement in the morning window", key))
+                // 今日はこれから朝の測定があるかもしれない
+                if today {
+                    continue
+                }
+            }
+        case daily_average:
+            if today {
+                Logger.Debug(fmt.Sprintf("Skip %s until the day is over (%s)", key, policy))
+                continue
+            }
+            avg := average(ms)
+            selected = &avg
+        }
+        Logger.Debug(fmt.Sprintf("Daily %s: %d measurement(s) on %s", policy, len(ms), key))
+
+        if selected != nil {
+            ret = append(ret, *selected)
+        }
+        for _, m := range ms {
+            if selected == nil || quarantine_id(m) != quarantine_id(*selected) {
+                unselected = append(unselected, m)
+            }
+        }
+    }
+    return ret, unselected
+}
+
+// 項目ごとに測定されている(0でない)値の平均を取る
+func average(ms []measurement.BodyMeasurement) measurement.BodyMeasurement {
+    avg := func(get func(m *measurement.BodyMeasurement) float64) float64 {
+        sum := 0.0
+        n := 0
+        for i := range ms {
+            if v := get(&ms[i]); v > 0 {
+                sum += v
+                n++
+            }
+        }
+        if n == 0 {
+            return 0
+        }
+        return math.Round(sum / float64(n) * 100) / 100
+    }
+
+    ret := measurement.BodyMeasurement{
+        Date: ms[0].Date,
+        Weight: avg(func(m *measurement.BodyMeasurement) float64 { return m.Weight }),
+        BodyFat: avg(func(m *measurement.BodyMeasurement) float64 { return m.BodyFat }),
+        BMI: avg(func(m *measurement.BodyMeasurement) float64 { return m.BMI }),
+        MuscleMass: avg(func(m *measurement.BodyMeasurement) float64 { return m.MuscleMass }),
+        MuscleScore: avg(func(m *measurement.BodyMeasurement) float64 { return m.MuscleScore }),
+        VisceralFatLevel: avg(func(m *measurement.BodyMeasurement) float64 { return m.VisceralFatLevel }),
+        BasalMetabolicRate: avg(func(m *measurement.BodyMeasurement) float64 { return m.BasalMetabolicRate }),
+        BodyAge: avg(func(m *measurement.BodyMeasurement) float64 { return m.BodyAge }),
+        BoneMass: avg(func(m *measurement.BodyMeasurement) float64 { return m.BoneMass }),
+        Source: ms[0].Source,
+    }
+    // 機種が混ざっている場合は機種を空にする
+    ret.Model = ms[0].Model
+    for _, m := range ms {
+        if m.Model != ret.Model {
+            ret.Model = ""
+            break
+        }
+    }
+    return ret
+}
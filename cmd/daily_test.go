@@ -0,0 +1,134 @@
+package main
+
+import (
+    "fmt"
+    "testing"
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+var jst = time.FixedZone("JST", 9 * 60 * 60)
+
+func at(day int, hour int, min int) time.Time {
+    return time.Date(2024, 1, day, hour, min, 0, 0, jst)
+}
+
+func bm(date time.Time, weight float64) measurement.BodyMeasurement {
+    return measurement.BodyMeasurement{Date: date, Weight: weight}
+}
+
+func dates(ms []measurement.BodyMeasurement) []string {
+    var ret []string
+    for _, m := range ms {
+        ret = append(ret, fmt.Sprintf("%s %.2f", m.Date.Format("01-02 15:04"), m.Weight))
+    }
+    return ret
+}
+
+func TestAggregate(t *testing.T) {
+    data := []measurement.BodyMeasurement{
+        bm(at(2, 22, 30), 61.0),
+        bm(at(1, 7, 0), 60.0),
+        bm(at(1, 3, 30), 60.4),
+        bm(at(1, 22, 30), 60.8),
+        bm(at(2, 11, 0), 60.6),
+        bm(at(3, 6, 0), 60.2),
+        bm(at(3, 8, 0), 60.3),
+    }
+    // 3日の途中
+    now := at(3, 12, 0)
+
+    tests := []struct {
+        policy string
+        morning_start string
+        morning_end string
+        want []string
+        unselected []string
+    }{
+        {daily_all, "", "", dates(data), nil},
+        {daily_first, "", "", []string{"01-01 03:30 60.40", "01-02 11:00 60.60", "01-03 06:00 60.20"},
+            []string{"01-01 07:00 60.00", "01-01 22:30 60.80", "01-02 22:30 61.00", "01-03 08:00 60.30"}},
+        // 最後の測定はその日が終わるまで送信しない
+        {daily_last, "", "", []string{"01-01 22:30 60.80", "01-02 22:30 61.00"},
+            []string{"01-01 03:30 60.40", "01-01 07:00 60.00", "01-02 11:00 60.60"}},
+        // 朝の測定が無い日は送信しない
+        {daily_morning, "", "", []string{"01-01 07:00 60.00", "01-03 06:00 60.20"},
+            []string{"01-01 03:30 60.40", "01-01 22:30 60.80", "01-02 11:00 60.60", "01-02 22:30 61.00", "01-03 08:00 60.30"}},
+        {daily_morning, "06:30", "12:00", []string{"01-01 07:00 60.00", "01-02 11:00 60.60", "01-03 08:00 60.30"},
+            []string{"01-01 03:30 60.40", "01-01 22:30 60.80", "01-02 22:30 61.00", "01-03 06:00 60.20"}},
+        // 平均は最初の測定の日時で、その日が終わるまで送信しない
+        {daily_average, "", "", []string{"01-01 03:30 60.40", "01-02 11:00 60.80"},
+            []string{"01-01 07:00 60.00", "01-01 22:30 60.80", "01-02 22:30 61.00"}},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.policy + tt.morning_start, func(t *testing.T) {
+            sc := &syncConfig{Daily: tt.policy, MorningStart: tt.morning_start, MorningEnd: tt.morning_end}
+            selected, unselected := sc.aggregate(data, now)
+            got := dates(selected)
+            if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+                t.Errorf("got  %v\nwant %v", got, tt.want)
+            }
+            if got := dates(unselected); fmt.Sprint(got) != fmt.Sprint(tt.unselected) {
+                t.Errorf("unselected %v\nwant       %v", got, tt.unselected)
+            }
+        })
+    }
+}
+
+// 日付は測定日時のタイムゾーンで区切り、今日かどうかも同じタイムゾーンで判断する
+func TestAggregateTimezone(t *testing.T) {
+    data := []measurement.BodyMeasurement{
+        bm(at(1, 23, 0), 60.0),
+        bm(at(2, 1, 0), 61.0),
+    }
+    // JSTでは2日、UTCではまだ1日
+    now := at(2, 8, 0).In(time.UTC)
+
+    sc := &syncConfig{Daily: daily_last}
+    selected, _ := sc.aggregate(data, now)
+    got := dates(selected)
+    if fmt.Sprint(got) != fmt.Sprint([]string{"01-01 23:00 60.00"}) {
+        t.Errorf("got %v", got)
+    }
+
+    // 今日の朝の測定はまだ無いかもしれないので、選ばなかった測定にもしない
+    sc = &syncConfig{Daily: daily_morning}
+    selected, unselected := sc.aggregate([]measurement.BodyMeasurement{bm(at(2, 3, 0), 60.0)}, now)
+    if len(selected) != 0 || len(unselected) != 0 {
+        t.Errorf("selected = %v, unselected = %v", dates(selected), dates(unselected))
+    }
+
+    sc = &syncConfig{Daily: daily_first}
+    if got, _ := sc.aggregate(nil, now); len(got) != 0 {
+        t.Errorf("got %v", got)
+    }
+}
+
+func TestAverage(t *testing.T) {
+    ms := []measurement.BodyMeasurement{
+        {Date: at(1, 7, 0), Weight: 60.0, BodyFat: 20.0, BMI: 21.0, Model: "A", Source: "HealthPlanet"},
+        {Date: at(1, 12, 0), Weight: 60.5, BodyFat: 0, BMI: 21.2, Model: "A"},
+        {Date: at(1, 20, 0), Weight: 61.0, BodyFat: 21.0, BMI: 0, Model: "A"},
+    }
+
+    got := average(ms)
+    // 測定されていない(0の)値は平均に含めない
+    if !got.Date.Equal(ms[0].Date) || got.Weight != 60.5 || got.BodyFat != 20.5 || got.BMI != 21.1 || got.MuscleMass != 0 {
+        t.Errorf("average = %+v", got)
+    }
+    if got.Model != "A" || got.Source != "HealthPlanet" {
+        t.Errorf("model = %q, source = %q", got.Model, got.Source)
+    }
+
+    // 小数第2位に丸める、機種が混ざっている場合は機種を空にする
+    ms = []measurement.BodyMeasurement{
+        {Date: at(1, 7, 0), Weight: 60.0, Model: "A"},
+        {Date: at(1, 8, 0), Weight: 60.1, Model: "B"},
+        {Date: at(1, 9, 0), Weight: 60.1, Model: "A"},
+    }
+    got = average(ms)
+    if got.Weight != 60.07 || got.Model != "" {
+        t.Errorf("average = %+v", got)
+    }
+}
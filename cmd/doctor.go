@@ -0,0 +1,210 @@
+package main
+
+import (
+    "fmt"
+    "errors"
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/health_planet"
+    "github.com/kamaboko123/tanita_to_fitbit/fitbit"
+    "github.com/kamaboko123/tanita_to_fitbit/withings"
+    "github.com/kamaboko123/tanita_to_fitbit/csv_source"
+)
+
+const (
+    check_pass = "PASS"
+    check_warn = "WARN"
+    check_fail = "FAIL"
+    check_skip = "SKIP"
+)
+
+type checklist struct {
+    failed bool
+}
+
+func (c *checklist) report(status string, format string, a ...any) {
+    fmt.Printf("  [%s] %s\n", status, fmt.Sprintf(format, a...))
+    if status == check_fail {
+        c.failed = true
+    }
+}
+
+func format_until(t time.Time) string {
+    d := time.Until(t).Round(time.Minute)
+    if d < 0 {
+        return fmt.Sprintf("%s (%s ago)", t.Format(time.RFC3339), -d)
+    }
+    return fmt.Sprintf("%s (in %s)", t.Format(time.RFC3339), d)
+}
+
+func doctor_healthplanet(p profile, c *checklist) {
+    hp_tz, _ := time.LoadLocation(p.HealthPlanet.Timezone)
+    hp_auth := get_healthplanet_auth(p)
+    err := hp_auth.LoadToken()
+    if err != nil {
+        c.report(check_fail, "HealthPlanet token load: %s", err)
+        c.report(check_skip, "HealthPlanet API")
+        return
+    }
+    c.report(check_pass, "HealthPlanet token load: %s", p.HealthPlanet.TokenFile)
+
+    token := hp_auth.Token()
+    if token.IsTokenExpired() {
+        c.report(check_fail, "HealthPlanet token expiry: %s, run -m init_healthplanet again", format_until(token.ExpiresAt()))
+        c.report(check_skip, "HealthPlanet API")
+        return
+    } else if token.IsTokenNeedRefresh() {
+        c.report(check_warn, "HealthPlanet token expiry: %s, will be refreshed on next sync", format_until(token.ExpiresAt()))
+    } else {
+        c.report(check_pass, "HealthPlanet token expiry: %s", format_until(token.ExpiresAt()))
+    }
+
+    hp := health_planet.NewClient(p.HealthPlanet.Url, hp_auth, Logger.With("profile", p.Name), hp_tz)
+    data, err := hp.GetInnerscanData()
+    if err != nil {
+        c.report(check_fail, "HealthPlanet API: %s", err)
+        return
+    }
+    c.report(check_pass, "HealthPlanet API: %d measurement(s) in the last 7 days", len(data))
+}
+
+func doctor_csv(p profile, c *checklist) {
+    src, err := csv_source.NewSource(*p.CSV, Logger.With("profile", p.Name))
+    if err != nil {
+        c.report(check_fail, "CSV source: %s", err)
+        return
+    }
+    data, err := src.Measurements(time.Time{}, time.Now())
+    if err != nil {
+        c.report(check_fail, "CSV source: %s", err)
+        return
+    }
+    c.report(check_pass, "CSV source: %d measurement(s) in %s", len(data), p.CSV.Path)
+}
+
+func doctor_fitbit(p profile, c *checklist) {
+    fb_tz, _ := time.LoadLocation(p.Fitbit.Timezone)
+    fb_auth := get_fitbit_auth(p)
+    err := fb_auth.LoadToken()
+    if err != nil {
+        c.report(check_fail, "Fitbit token load: %s", err)
+        c.report(check_skip, "Fitbit API")
+        return
+    }
+    c.report(check_pass, "Fitbit token load: %s", p.Fitbit.TokenFile)
+
+    token := fb_auth.Token()
+    if token.HasScope("weight") {
+        c.report(check_pass, "Fitbit token scope: includes \"weight\"")
+    } else {
+        c.report(check_fail, "Fitbit token scope: \"weight\" is missing (scope: %q)", token.Scope)
+    }
+    if p.Sync.Pedometer && !token.HasScope("activity") {
+        c.report(check_fail, "Fitbit token scope: \"activity\" is missing for pedometer sync (scope: %q)", token.Scope)
+    }
+
+    // Fitbitのアクセストークンは8時間で失効するので、期限切れの場合は同期と同じようにリフレッシュしてから確認する
+    expires_at, ok := token.ExpiresAt()
+    if !ok {
+        c.report(check_warn, "Fitbit token expiry: unknown (not refreshed by this tool yet)")
+    } else if token.IsTokenExpired() {
+        c.report(check_warn, "Fitbit token expiry: access token %s, refreshing", format_until(expires_at))
+    } else {
+        c.report(check_pass, "Fitbit token expiry: %s", format_until(expires_at))
+    }
+    if !ok || token.IsTokenExpired() {
+        err = fb_auth.RefreshToken()
+        if err != nil {
+            c.report(check_fail, "Fitbit token refresh: %s", err)
+            c.report(check_skip, "Fitbit API")
+            return
+        }
+        expires_at, _ = fb_auth.Token().ExpiresAt()
+        c.report(check_pass, "Fitbit token refresh: new access token expires %s", format_until(expires_at))
+    }
+
+    fb := fitbit.NewClient(p.Fitbit.Url, fb_auth, Logger.With("profile", p.Name), fb_tz)
+    fb_profile, err := fb.GetProfile()
+    if err != nil {
+        c.report(check_fail, "Fitbit API: %s", err)
+        return
+    }
+    c.report(check_pass, "Fitbit API: authenticated as %q", fb_profile.User.DisplayName)
+
+    // Fitbitは日時をプロフィールのタイムゾーンの現地時刻として記録するので、設定と違うと記録される時刻がずれる
+    if fb_profile.User.Timezone != "" && fb_profile.User.Timezone != p.Fitbit.Timezone {
+        c.report(check_warn, "Fitbit timezone: profile is %q but fitbit.timezone is %q, recorded times will be shifted", fb_profile.User.Timezone, p.Fitbit.Timezone)
+    } else {
+        c.report(check_pass, "Fitbit timezone: %s", p.Fitbit.Timezone)
+    }
+}
+
+func doctor_withings(p profile, c *checklist) {
+    wi_tz, _ := time.LoadLocation(p.Withings.Timezone)
+    wi_auth := get_withings_auth(p)
+    err := wi_auth.LoadToken()
+    if err != nil {
+        c.report(check_fail, "Withings token load: %s", err)
+        c.report(check_skip, "Withings API")
+        return
+    }
+    c.report(check_pass, "Withings token load: %s", p.Withings.TokenFile)
+
+    token := wi_auth.Token()
+    if token.HasScope("user.metrics") {
+        c.report(check_pass, "Withings token scope: includes \"user.metrics\"")
+    } else {
+        c.report(check_fail, "Withings token scope: \"user.metrics\" is missing (scope: %q)", token.Scope)
+    }
+
+    // アクセストークンは3時間で失効するので、同期と同じようにリフレッシュしてから確認する
+    if token.IsTokenNeedRefresh() {
+        err = wi_auth.RefreshToken()
+        if err != nil {
+            c.report(check_fail, "Withings token refresh: %s", err)
+            c.report(check_skip, "Withings API")
+            return
+        }
+    }
+    expires_at, _ := wi_auth.Token().ExpiresAt()
+    c.report(check_pass, "Withings token expiry: %s", format_until(expires_at))
+
+    wi := withings.NewClient(p.Withings.Url, wi_auth, Logger.With("profile", p.Name), wi_tz)
+    now := time.Now()
+    resp, err := wi.GetMeasures(now.AddDate(0, 0, -7), now)
+    if err != nil {
+        c.report(check_fail, "Withings API: %s", err)
+        return
+    }
+    c.report(check_pass, "Withings API: %d measurement(s) in the last 7 days", len(resp.MeasureGroups))
+}
+
+// 設定・トークン・APIへの疎通を順番に確認してチェックリストを表示する
+func run_doctor(conf config, profile_name string) error {
+    profiles, err := conf.SelectProfiles(profile_name)
+    if err != nil {
+        return err
+    }
+
+    c := &checklist{}
+    for _, p := range profiles {
+        fmt.Printf("[%s]\n", p.Name)
+        c.report(check_pass, "Config")
+        switch p.Source {
+        case source_csv:
+            doctor_csv(p, c)
+        case source_health_planet:
+            doctor_healthplanet(p, c)
+        }
+        if p.Source == source_fitbit || p.HasSink(sink_fitbit) || p.Sync.Pedometer {
+            doctor_fitbit(p, c)
+        }
+        if p.HasSink(sink_withings) {
+            doctor_withings(p, c)
+        }
+    }
+
+    if c.failed {
+        return errors.New("Some checks failed")
+    }
+    return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "reflect"
+    "strconv"
+    "strings"
+    "errors"
+)
+
+const env_prefix = "TANITA_TO_FITBIT"
+
+func env_name(parts ...string) string {
+    name := strings.Join(parts, "_")
+    name = strings.ToUpper(name)
+    return strings.Map(func(r rune) rune {
+        if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+            return r
+        }
+        return '_'
+    }, name)
+}
+
+// 設定ファイルの各項目を環境変数で上書きする
+// 変数名はjsonのキーをつなげたもの (例: TANITA_TO_FITBIT_FITBIT_CLIENT_SECRET)
+// profilesは名前で指定する (例: TANITA_TO_FITBIT_PROFILES_ALICE_FITBIT_TOKEN_FILE)
+// 設定ファイルに無いセクション(influxdbなど)も、環境変数がある場合は作成する
+func apply_env_overrides(c *config) error {
+    _, err := apply_env(reflect.ValueOf(c).Elem(), reflect.Value{}, env_prefix)
+    return err
+}
+
+// baseは未設定のセクションを作成する場合に元にする値 (プロファイルの場合はトップレベルの同じ項目)
+// 環境変数で値を設定した場合はtrueを返す
+func apply_env(v reflect.Value, base reflect.Value, prefix string) (bool, error) {
+    switch v.Kind() {
+    case reflect.Struct:
+        applied := false
+        t := v.Type()
+        for i := 0; i < t.NumField(); i++ {
+            f := t.Field(i)
+            if !f.IsExported() {
+                continue
+            }
+            key := strings.Split(f.Tag.Get("json"), ",")[0]
+            if key == "" || key == "-" {
+                continue
+            }
+            var field_base reflect.Value
+            if f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.Struct {
+                // プロファイルは未設定の項目をトップレベルから引き継ぐので、上書き済みのトップレベルの値を元にする
+                field_base = v
+            } else if base.IsValid() && base.Kind() == reflect.Struct {
+                if bf := base.FieldByName(f.Name); bf.IsValid() && bf.Type() == f.Type {
+                    field_base = bf
+                }
+            }
+            ok, err := apply_env(v.Field(i), field_base, env_name(prefix, key))
+            if err != nil {
+                return false, err
+            }
+            applied = applied || ok
+        }
+        return applied, nil
+    case reflect.Pointer:
+        var base_elem reflect.Value
+        if base.IsValid() && base.Kind() == reflect.Pointer && !base.IsNil() {
+            base_elem = base.Elem()
+        }
+        shared := base_elem.IsValid() && !v.IsNil() && v.Pointer() == base.Pointer()
+        if !v.IsNil() && !shared {
+            return apply_env(v.Elem(), base_elem, prefix)
+        }
+        // 環境変数がある場合だけセクションを作成する (無い場合は未設定のまま)
+        // トップレベルと共有している場合は、トップレベルを書き換えないようにコピーしてから上書きする
+        n := reflect.New(v.Type().Elem())
+        if base_elem.IsValid() {
+            n.Elem().Set(base_elem)
+        }
+        applied, err := apply_env(n.Elem(), base_elem, prefix)
+        if err != nil || !applied {
+            return false, err
+        }
+        v.Set(n)
+        return true, nil
+    case reflect.Slice:
+        if v.Type().Elem().Kind() != reflect.Struct {
+            break
+        }
+        applied := false
+        for i := 0; i < v.Len(); i++ {
+            elem := v.Index(i)
+            key := strconv.Itoa(i)
+            if name := elem.FieldByName("Name"); name.IsValid() && name.Kind() == reflect.String && name.String() != "" {
+                key = name.String()
+            }
+            ok, err := apply_env(elem, base, env_name(prefix, key))
+            if err != nil {
+                return false, err
+            }
+            applied = applied || ok
+        }
+        return applied, nil
+    }
+
+    value, ok := os.LookupEnv(prefix)
+    if !ok {
+        return false, nil
+    }
+
+    switch v.Kind() {
+    case reflect.String:
+        v.SetString(value)
+    case reflect.Bool:
+        b, err := strconv.ParseBool(value)
+        if err != nil {
+            return false, errors.New(fmt.Sprintf("Invalid value of %s: %s", prefix, err))
+        }
+        v.SetBool(b)
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+        if err != nil {
+            return false, errors.New(fmt.Sprintf("Invalid value of %s: %s", prefix, err))
+        }
+        v.SetInt(n)
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+        if err != nil {
+            return false, errors.New(fmt.Sprintf("Invalid value of %s: %s", prefix, err))
+        }
+        v.SetUint(n)
+    case reflect.Float32, reflect.Float64:
+        n, err := strconv.ParseFloat(value, v.Type().Bits())
+        if err != nil {
+            return false, errors.New(fmt.Sprintf("Invalid value of %s: %s", prefix, err))
+        }
+        v.SetFloat(n)
+    case reflect.Slice:
+        if v.Type().Elem().Kind() != reflect.String {
+            return false, errors.New(fmt.Sprintf("%s can not be set by environment variable", prefix))
+        }
+        v.Set(reflect.ValueOf(strings.Split(value, ",")))
+    case reflect.Map:
+        // "key=value,key=value"の形式 (設定ファイルの値は丸ごと置き換える)
+        if v.Type().Key().Kind() != reflect.String || v.Type().Elem().Kind() != reflect.String {
+            return false, errors.New(fmt.Sprintf("%s can not be set by environment variable", prefix))
+        }
+        m := make(map[string]string)
+        if value != "" {
+            for _, kv := range strings.Split(value, ",") {
+                k, val, found := strings.Cut(kv, "=")
+                if !found || strings.TrimSpace(k) == "" {
+                    return false, errors.New(fmt.Sprintf("Invalid value of %s: %s (expected key=value,...)", prefix, kv))
+                }
+                m[strings.TrimSpace(k)] = val
+            }
+        }
+        v.Set(reflect.ValueOf(m).Convert(v.Type()))
+    default:
+        return false, errors.New(fmt.Sprintf("%s can not be set by environment variable", prefix))
+    }
+
+    return true, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func write_test_config(t *testing.T, data string) string {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), "config.json")
+    err := os.WriteFile(path, []byte(data), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+func TestEnvOverrides(t *testing.T) {
+    path := write_test_config(t, `{
+        "fitbit": {"client_id": "file"},
+        "sync": {"filter": {"min_weight": 40}},
+        "influxdb": {"url": "http://top", "database": "body"},
+        "profiles": [
+            {"name": "alice"},
+            {"name": "bob", "influxdb": {"url": "http://bob"}}
+        ]
+    }`)
+    t.Setenv("TANITA_TO_FITBIT_FITBIT_CLIENT_ID", "env")
+    t.Setenv("TANITA_TO_FITBIT_INFLUXDB_TOKEN", "top-token")
+    t.Setenv("TANITA_TO_FITBIT_INFLUXDB_TAGS", "user=all,host=nas")
+    t.Setenv("TANITA_TO_FITBIT_PROFILES_ALICE_INFLUXDB_DATABASE", "alice")
+    t.Setenv("TANITA_TO_FITBIT_PROFILES_ALICE_SYNC_FILTER_MAX_WEIGHT", "90")
+    t.Setenv("TANITA_TO_FITBIT_MQTT_QOS", "2")
+    t.Setenv("TANITA_TO_FITBIT_WEBHOOK_URL", "http://hook")
+    t.Setenv("TANITA_TO_FITBIT_WEBHOOK_MAX_RETRIES", "0")
+
+    c, err := load_config(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if c.Fitbit.ClientId != "env" {
+        t.Errorf("fitbit.client_id = %q", c.Fitbit.ClientId)
+    }
+    if c.InfluxDB.Token != "top-token" || c.InfluxDB.Url != "http://top" {
+        t.Errorf("influxdb = %+v", c.InfluxDB)
+    }
+    if c.InfluxDB.Tags["user"] != "all" || c.InfluxDB.Tags["host"] != "nas" {
+        t.Errorf("influxdb.tags = %v", c.InfluxDB.Tags)
+    }
+    // 設定ファイルに無いセクションも作成する
+    if c.MQTT == nil || c.MQTT.Qos != 2 {
+        t.Errorf("mqtt = %+v", c.MQTT)
+    }
+    if c.Webhook == nil || c.Webhook.Url != "http://hook" {
+        t.Fatalf("webhook = %+v", c.Webhook)
+    }
+    // 0を設定した場合は既定値と区別できる
+    if c.Webhook.MaxRetries == nil || *c.Webhook.MaxRetries != 0 || c.Webhook.RetryInterval != nil {
+        t.Errorf("webhook retries = %v, %v", c.Webhook.MaxRetries, c.Webhook.RetryInterval)
+    }
+
+    // プロファイルのセクションはトップレベルの値を元に作成する
+    alice := c.Profiles[0]
+    if alice.InfluxDB == nil || alice.InfluxDB.Database != "alice" || alice.InfluxDB.Url != "http://top" || alice.InfluxDB.Token != "top-token" {
+        t.Errorf("alice influxdb = %+v", alice.InfluxDB)
+    }
+    if alice.Sync == nil || alice.Sync.Filter == nil || alice.Sync.Filter.MaxWeight != 90 || alice.Sync.Filter.MinWeight != 40 {
+        t.Errorf("alice sync = %+v", alice.Sync)
+    }
+    // トップレベルは書き換えない
+    if c.InfluxDB.Database != "body" || c.Sync.Filter.MaxWeight != 0 {
+        t.Errorf("top-level changed: influxdb = %+v, filter = %+v", c.InfluxDB, c.Sync.Filter)
+    }
+
+    // 環境変数が無いプロファイルはそのまま (トップレベルを引き継ぐ)
+    bob := c.Profiles[1]
+    if bob.InfluxDB.Url != "http://bob" || bob.InfluxDB.Token != "" || bob.Sync != nil {
+        t.Errorf("bob = %+v %+v", bob.InfluxDB, bob.Sync)
+    }
+}
+
+func TestEnvOverridesInvalid(t *testing.T) {
+    tests := []struct {
+        name string
+        value string
+    }{
+        {"TANITA_TO_FITBIT_MQTT_QOS", "256"},
+        {"TANITA_TO_FITBIT_MQTT_QOS", "-1"},
+        {"TANITA_TO_FITBIT_WEBHOOK_MAX_RETRIES", "x"},
+        {"TANITA_TO_FITBIT_WEBHOOK_HEADERS", "X-Token"},
+        {"TANITA_TO_FITBIT_SYNC_PEDOMETER", "maybe"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name + "=" + tt.value, func(t *testing.T) {
+            path := write_test_config(t, `{}`)
+            t.Setenv(tt.name, tt.value)
+            _, err := load_config(path)
+            if err == nil {
+                t.Errorf("expected error")
+            }
+        })
+    }
+}
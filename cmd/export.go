@@ -0,0 +1,195 @@
+package main
+
+import (
+    "fmt"
+    "errors"
+    "io"
+    "os"
+    "sort"
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/export"
+    "github.com/kamaboko123/tanita_to_fitbit/health_planet"
+)
+
+type exportArgs struct {
+    from string
+    to string
+    format string
+    output string
+}
+
+// -from/-toは日付(YYYY-MM-DD)で指定し、toの日は含む
+// 未指定の場合は直近7日間
+func (a *exportArgs) date_range(tz *time.Location) (time.Time, time.Time, error) {
+    now := time.Now().In(tz)
+    to := now
+    from := now.AddDate(0, 0, -7)
+
+    if a.from != "" {
+        d, err := time.ParseInLocation("2006-01-02", a.from, tz)
+        if err != nil {
+            return from, to, errors.New(fmt.Sprintf("Invalid -from: %s", err))
+        }
+        from = d
+    }
+    if a.to != "" {
+        d, err := time.ParseInLocation("2006-01-02", a.to, tz)
+        if err != nil {
+            return from, to, errors.New(fmt.Sprintf("Invalid -to: %s", err))
+        }
+        to = d.AddDate(0, 0, 1).Add(-time.Second)
+    }
+    if to.Before(from) {
+        return from, to, errors.New("-from must be before -to")
+    }
+
+    return from, to, nil
+}
+
+type nopCloser struct {
+    io.Writer
+}
+
+func (nopCloser) Close() error {
+    return nil
+}
+
+// -oが未指定の場合は標準出力に書き出す
+func (a *exportArgs) open() (io.WriteCloser, error) {
+    if a.output == "" || a.output == "-" {
+        return nopCloser{os.Stdout}, nil
+    }
+    return os.Create(a.output)
+}
+
+func (a *exportArgs) write(t *export.Table) error {
+    w, err := a.open()
+    if err != nil {
+        return err
+    }
+    err = export.Write(w, a.format, t)
+    if err != nil {
+        w.Close()
+        return err
+    }
+    return w.Close()
+}
+
+func run_bp_export(conf config, profile_name string, ea exportArgs) error {
+    p, err := select_single_profile(conf, profile_name)
+    if err != nil {
+        return err
+    }
+    hp, err := get_healthplanet_client(*p)
+    if err != nil {
+        return err
+    }
+
+    from, to, err := ea.date_range(hp.Timezone)
+    if err != nil {
+        return err
+    }
+    data, err := hp.GetBloodPressureData(from, to)
+    if err != nil {
+        return err
+    }
+
+    t := &export.Table{Columns: []string{"date", "systolic", "diastolic", "pulse", "model"}}
+    for _, d := range data {
+        t.Append(d.Date, d.Systolic, d.Diastolic, d.Pulse, d.Model)
+    }
+    return ea.write(t)
+}
+
+// 測定されていない項目(0)はCSVでは空欄、JSONではnullにする
+func optional(v float64) any {
+    if v == 0 {
+        return nil
+    }
+    return v
+}
+
+// 3ヶ月より長い期間はGetInnerscanDataRangeが重複しないように分割して取得する
+func get_innerscan_data(hp *health_planet.Client, from time.Time, to time.Time) ([]*health_planet.InnerscanData, *health_planet.Profile, error) {
+    data, profile, err := hp.GetInnerscanDataRange(health_planet.DateTypeMeasurement, from, to)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    var ret []*health_planet.InnerscanData
+    for _, d := range data {
+        ret = append(ret, d)
+    }
+    // 同じ日時に別の機種で測定したデータは機種名順にして、出力の順番を毎回同じにする
+    sort.Slice(ret, func(i, j int) bool {
+        if !ret[i].Date.Equal(ret[j].Date) {
+            return ret[i].Date.Before(ret[j].Date)
+        }
+        return ret[i].Model < ret[j].Model
+    })
+
+    return ret, profile, nil
+}
+
+// 測定日時がfrom〜toの体組成データを全ての項目で書き出す
+func run_export(conf config, profile_name string, ea exportArgs) error {
+    p, err := select_single_profile(conf, profile_name)
+    if err != nil {
+        return err
+    }
+    hp, err := get_healthplanet_client(*p)
+    if err != nil {
+        return err
+    }
+
+    from, to, err := ea.date_range(hp.Timezone)
+    if err != nil {
+        return err
+    }
+    data, hp_profile, err := get_innerscan_data(hp, from, to)
+    if err != nil {
+        return err
+    }
+
+    t := &export.Table{Columns: []string{
+        "date", "weight", "body_fat", "bmi", "muscle_mass", "muscle_score", "visceral_fat_level2",
+        "visceral_fat_level", "basal_metabolic_rate", "body_age", "bone_mass", "model",
+    }}
+    for _, d := range data {
+        t.Append(
+            d.Date, optional(d.Weight), optional(d.BodyFat), optional(hp_profile.BMI(d.Weight)),
+            optional(d.MuscleMass), optional(d.MuscleScore), optional(d.VisceralFatLevel2),
+            optional(d.VisceralFatLevel), optional(d.BasalMetabolicRate), optional(d.BodyAge),
+            optional(d.BoneMass), d.Model,
+        )
+    }
+    return ea.write(t)
+}
+
+// Fitbitの体重・体脂肪率の記録を書き出す
+// sourceでこのツール(API)が書き込んだものとAria/Webで記録されたものを区別できる
+func run_fitbit_export(conf config, profile_name string, ea exportArgs) error {
+    p, err := select_single_profile(conf, profile_name)
+    if err != nil {
+        return err
+    }
+    fb, err := get_fitbit_client(*p)
+    if err != nil {
+        return err
+    }
+
+    from, to, err := ea.date_range(fb.Timezone)
+    if err != nil {
+        return err
+    }
+    weight_logs, err := fb.GetWeightLogs(from, to)
+    if err != nil {
+        return err
+    }
+
+    t := &export.Table{Columns: []string{"date", "weight", "fat", "bmi", "log_id", "source"}}
+    for _, wl := range weight_logs {
+        t.Append(wl.Date, wl.Weight, optional(wl.Fat), optional(wl.Bmi), wl.LogId, wl.Source)
+    }
+    return ea.write(t)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+    "fmt"
+    "math"
+    "sort"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+// 家族や来客の測定、測定ミスを送信しないための確認
+// 0の項目は確認しない
+type filterConfig struct {
+    // 体重の範囲(kg)
+    MinWeight float64 `json:"min_weight"`
+    MaxWeight float64 `json:"max_weight"`
+    // 最近の測定の中央値からの体重の差(kg)
+    MaxDeviation float64 `json:"max_deviation"`
+    // 中央値を取る最近の測定の件数 (省略時は10件、min_history件未満の間は確認しない)
+    MedianCount int `json:"median_count"`
+    MinHistory int `json:"min_history"`
+    // 体脂肪率の範囲(%) (体脂肪率が無い測定は確認しない)
+    MinBodyFat float64 `json:"min_body_fat"`
+    MaxBodyFat float64 `json:"max_body_fat"`
+}
+
+const default_median_count = 10
+const default_min_history = 3
+
+func (f *filterConfig) median_count() int {
+    if f.MedianCount <= 0 {
+        return default_median_count
+    }
+    return f.MedianCount
+}
+
+func (f *filterConfig) min_history() int {
+    if f.MinHistory <= 0 {
+        return default_min_history
+    }
+    return f.MinHistory
+}
+
+func median(values []float64) float64 {
+    sorted := append([]float64{}, values...)
+    sort.Float64s(sorted)
+    n := len(sorted)
+    if n % 2 == 1 {
+        return sorted[n / 2]
+    }
+    return (sorted[n / 2 - 1] + sorted[n / 2]) / 2
+}
+
+// 送信しない理由を返す (問題が無い場合は空文字列)
+// recentはmより前の最近の測定の体重
+func (f *filterConfig) Check(m measurement.BodyMeasurement, recent []float64) string {
+    if f.MinWeight > 0 && m.Weight < f.MinWeight {
+        return fmt.Sprintf("weight %.2fkg is below min_weight %.2fkg", m.Weight, f.MinWeight)
+    }
+    if f.MaxWeight > 0 && m.Weight > f.MaxWeight {
+        return fmt.Sprintf("weight %.2fkg is above max_weight %.2fkg", m.Weight, f.MaxWeight)
+    }
+    if f.MaxDeviation > 0 && len(recent) >= f.min_history() {
+        med := median(recent)
+        if math.Abs(m.Weight - med) > f.MaxDeviation {
+            return fmt.Sprintf("weight %.2fkg differs from recent median %.2fkg by more than %.2fkg", m.Weight, med, f.MaxDeviation)
+        }
+    }
+    if m.BodyFat > 0 {
+        if f.MinBodyFat > 0 && m.BodyFat < f.MinBodyFat {
+            return fmt.Sprintf("body fat %.1f%% is below min_body_fat %.1f%%", m.BodyFat, f.MinBodyFat)
+        }
+        if f.MaxBodyFat > 0 && m.BodyFat > f.MaxBodyFat {
+            return fmt.Sprintf("body fat %.1f%% is above max_body_fat %.1f%%", m.BodyFat, f.MaxBodyFat)
+        }
+    }
+    return ""
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+    "testing"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+func TestMedian(t *testing.T) {
+    tests := []struct {
+        values []float64
+        want float64
+    }{
+        {[]float64{60}, 60},
+        {[]float64{61, 59, 60}, 60},
+        {[]float64{62, 60, 59, 61}, 60.5},
+        {[]float64{60, 60, 90}, 60},
+    }
+    for _, tt := range tests {
+        values := append([]float64{}, tt.values...)
+        if got := median(values); got != tt.want {
+            t.Errorf("median(%v) = %v, want %v", tt.values, got, tt.want)
+        }
+        // 引数は並べ替えない
+        for i := range values {
+            if values[i] != tt.values[i] {
+                t.Errorf("median sorted the argument: %v", values)
+            }
+        }
+    }
+}
+
+func TestFilterCheck(t *testing.T) {
+    f := &filterConfig{MinWeight: 40, MaxWeight: 100, MaxDeviation: 3, MinBodyFat: 5, MaxBodyFat: 50}
+    history := []float64{60, 61, 60.5, 59.5}
+
+    tests := []struct {
+        name string
+        f *filterConfig
+        m measurement.BodyMeasurement
+        recent []float64
+        reject bool
+    }{
+        {"ok", f, measurement.BodyMeasurement{Weight: 61, BodyFat: 20}, history, false},
+        {"below min_weight", f, measurement.BodyMeasurement{Weight: 25}, nil, true},
+        {"above max_weight", f, measurement.BodyMeasurement{Weight: 101}, nil, true},
+        {"deviation", f, measurement.BodyMeasurement{Weight: 64}, history, true},
+        {"deviation at limit", f, measurement.BodyMeasurement{Weight: 63.25}, history, false},
+        // 最近の測定がmin_history件未満の間は中央値で確認しない
+        {"not enough history", f, measurement.BodyMeasurement{Weight: 80}, history[:2], false},
+        {"min_history", &filterConfig{MaxDeviation: 3, MinHistory: 2}, measurement.BodyMeasurement{Weight: 80}, history[:2], true},
+        {"below min_body_fat", f, measurement.BodyMeasurement{Weight: 60, BodyFat: 3}, history, true},
+        {"above max_body_fat", f, measurement.BodyMeasurement{Weight: 60, BodyFat: 55}, history, true},
+        // 体脂肪率が無い測定は体脂肪率を確認しない
+        {"no body fat", f, measurement.BodyMeasurement{Weight: 60}, history, false},
+        // 0の項目は確認しない
+        {"disabled", &filterConfig{}, measurement.BodyMeasurement{Weight: 300, BodyFat: 90}, history, false},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            reason := tt.f.Check(tt.m, tt.recent)
+            if (reason != "") != tt.reject {
+                t.Errorf("Check = %q, reject = %v", reason, tt.reject)
+            }
+        })
+    }
+}
+
+func TestFilterDefaults(t *testing.T) {
+    f := &filterConfig{}
+    if f.median_count() != default_median_count || f.min_history() != default_min_history {
+        t.Errorf("median_count = %d, min_history = %d", f.median_count(), f.min_history())
+    }
+    f = &filterConfig{MedianCount: 5, MinHistory: 1}
+    if f.median_count() != 5 || f.min_history() != 1 {
+        t.Errorf("median_count = %d, min_history = %d", f.median_count(), f.min_history())
+    }
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+    "fmt"
+    "errors"
+)
+
+func logout_healthplanet(p profile, archive bool) error {
+    // HealthPlanetにはトークンを失効させるAPIが無いので、ローカルのトークンを消すだけ
+    // (連携の解除はHealthPlanetのサイトから行う)
+    hp_auth := get_healthplanet_auth(p)
+    err := hp_auth.LoadToken()
+    if err != nil {
+        return err
+    }
+    archive_path, err := hp_auth.RemoveToken(archive)
+    if err != nil {
+        return err
+    }
+
+    if archive {
+        fmt.Printf("[%s] healthplanet: token archived to %s\n", p.Name, archive_path)
+    } else {
+        fmt.Printf("[%s] healthplanet: token removed\n", p.Name)
+    }
+    fmt.Println("  HealthPlanet has no revoke API. If needed, remove the app from HealthPlanet account settings.")
+    return nil
+}
+
+func logout_fitbit(p profile, archive bool) error {
+    fb_auth := get_fitbit_auth(p)
+    err := fb_auth.LoadToken()
+    if err != nil {
+        return err
+    }
+
+    // 失効に失敗した場合はトークンを残して再実行できるようにする
+    err = fb_auth.RevokeToken()
+    if err != nil {
+        return err
+    }
+    archive_path, err := fb_auth.RemoveToken(archive)
+    if err != nil {
+        return err
+    }
+
+    if archive {
+        fmt.Printf("[%s] fitbit: token revoked and archived to %s\n", p.Name, archive_path)
+    } else {
+        fmt.Printf("[%s] fitbit: token revoked and removed\n", p.Name)
+    }
+    return nil
+}
+
+func logout_withings(p profile, archive bool) error {
+    // Withingsのトークン失効APIは署名(nonce)が必要なので、ローカルのトークンを消すだけ
+    // (連携の解除はWithingsのアカウント設定から行う)
+    wi_auth := get_withings_auth(p)
+    err := wi_auth.LoadToken()
+    if err != nil {
+        return err
+    }
+    archive_path, err := wi_auth.RemoveToken(archive)
+    if err != nil {
+        return err
+    }
+
+    if archive {
+        fmt.Printf("[%s] withings: token archived to %s\n", p.Name, archive_path)
+    } else {
+        fmt.Printf("[%s] withings: token removed\n", p.Name)
+    }
+    fmt.Println("  If needed, remove the app from Withings account settings.")
+    return nil
+}
+
+// トークンを失効・削除して、init_*で再初期化できる状態に戻す
+func run_logout(conf config, profile_name string, provider string, archive bool) error {
+    p, err := select_single_profile(conf, profile_name)
+    if err != nil {
+        return err
+    }
+    providers, err := select_providers(*p, provider)
+    if err != nil {
+        return err
+    }
+
+    for _, pr := range providers {
+        switch pr {
+        case provider_healthplanet:
+            err = logout_healthplanet(*p, archive)
+        case provider_fitbit:
+            err = logout_fitbit(*p, archive)
+        case provider_withings:
+            err = logout_withings(*p, archive)
+        }
+        if err != nil {
+            return errors.New(fmt.Sprintf("%s: %s", pr, err))
+        }
+    }
+    return nil
+}
@@ -2,35 +2,35 @@ package main
 
 import (
     "os"
-	"fmt"
+    "fmt"
     "flag"
-    "io/ioutil"
-    "encoding/json"
     "errors"
+    "strings"
+    "path/filepath"
     "log/slog"
     "time"
     "github.com/kamaboko123/tanita_to_fitbit/health_planet"
     "github.com/kamaboko123/tanita_to_fitbit/fitbit"
+    "github.com/kamaboko123/tanita_to_fitbit/withings"
+    "github.com/kamaboko123/tanita_to_fitbit/export"
+    "github.com/kamaboko123/tanita_to_fitbit/csv_source"
+    "github.com/kamaboko123/tanita_to_fitbit/influxdb"
+    "github.com/kamaboko123/tanita_to_fitbit/webhook"
+    "github.com/kamaboko123/tanita_to_fitbit/mqtt"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
 )
 
-const config_file = "config.json"
 var Logger *slog.Logger
 
-type config struct {
-    HealthPlanet struct {
-        ClientId string `json:"client_id"`
-        ClientSecret string `json:"client_secret"`
-        Timezone string `json:"timezone"`
-    } `json:"health_planet"`
-    Fitbit struct {
-        ClientId string `json:"client_id"`
-        ClientSecret string `json:"client_secret"`
-        Timezone string `json:"timezone"`
-    } `json:"fitbit"`
-}
-
 type RunArgs struct {
     mode string
+    profile string
+    provider string
+    config_path string
+    token_dir string
+    archive bool
+    id string
+    export exportArgs
     verbose bool
 }
 
@@ -43,109 +43,327 @@ func contains(arr []string, str string) bool {
     return false
 }
 
-func load_config() (*config, error) {
-    var c config
-    data, err := ioutil.ReadFile(config_file)
-    if err != nil {
-        return nil, err
-    }
-    err = json.Unmarshal(data, &c)
-    if err != nil {
-        return nil, err
-    }
-    return &c, nil
-}
-
-
 func get_run_args() (*RunArgs,error) {
     m := flag.String("m", "", "mode")
+    p := flag.String("p", "", "profile name (default: all profiles)")
+    pr := flag.String("provider", "", fmt.Sprintf("provider %s (default: all providers)", support_providers))
+    c := flag.String("config", "", "config file path")
+    t := flag.String("token-dir", "", "token directory")
+    a := flag.Bool("archive", false, "archive token file instead of removing it (logout)")
+    id := flag.String("id", "", "quarantined measurement id, or \"all\" (approve, reject)")
+    from := flag.String("from", "", "start date YYYY-MM-DD (export)")
+    to := flag.String("to", "", "end date YYYY-MM-DD (export)")
+    format := flag.String("format", export.FormatCSV, fmt.Sprintf("output format %s (export)", export.SupportFormats))
+    o := flag.String("o", "", "output file (export, default: stdout)")
     v := flag.Bool("v", false, "verbose")
 
     flag.Parse()
 
-    suppport_modes := []string{"sync", "dry-sync", "init_healthplanet", "init_fitbit"}
+    suppport_modes := []string{"sync", "dry-sync", "init_healthplanet", "init_fitbit", "init_withings", "check-config", "doctor", "token-status", "refresh-token", "logout", "bp-export", "export", "fitbit-export", "quarantine", "approve", "reject"}
     if !contains(suppport_modes, *m) {
         return nil, errors.New(fmt.Sprintf("Please set mode with -m. Support modes are %s", suppport_modes))
     }
 
     return &RunArgs{
         mode: *m,
+        profile: *p,
+        provider: *pr,
+        config_path: find_config_file(*c),
+        token_dir: *t,
+        archive: *a,
+        id: *id,
+        export: exportArgs{from: *from, to: *to, format: *format, output: *o},
         verbose: *v,
     }, nil
 }
 
-func get_healthplanet_auth(conf config) (*health_planet.Auth) {
-    tanita_client_id := conf.HealthPlanet.ClientId
-    tanita_client_secret := conf.HealthPlanet.ClientSecret
-    hp_auth := health_planet.NewAuth("https://www.healthplanet.jp", tanita_client_id, tanita_client_secret, "hp_token.json", Logger)
+func get_healthplanet_auth(p profile) (*health_planet.Auth) {
+    tanita_client_id := p.HealthPlanet.ClientId
+    tanita_client_secret := p.HealthPlanet.ClientSecret
+    hp_auth := health_planet.NewAuth(p.HealthPlanet.Url, tanita_client_id, tanita_client_secret, p.HealthPlanet.TokenFile, Logger.With("profile", p.Name))
     
     return hp_auth
 }
 
-func get_fitbit_auth(conf config) (*fitbit.Auth) {
-    fitbit_client_id := conf.Fitbit.ClientId
-    fitbit_client_secret := conf.Fitbit.ClientSecret
-    fb_auth := fitbit.NewAuth("https://api.fitbit.com", fitbit_client_id, fitbit_client_secret, "fb_token.json")
+func get_fitbit_auth(p profile) (*fitbit.Auth) {
+    fitbit_client_id := p.Fitbit.ClientId
+    fitbit_client_secret := p.Fitbit.ClientSecret
+    fb_auth := fitbit.NewAuth(p.Fitbit.Url, fitbit_client_id, fitbit_client_secret, p.Fitbit.TokenFile)
 
     return fb_auth
 }
 
-func run_init_healthplanet(conf config) error {
-    hp_auth := get_healthplanet_auth(conf)
-    err := hp_auth.InitToken()
+func get_withings_auth(p profile) (*withings.Auth) {
+    return withings.NewAuth(p.Withings.Url, p.Withings.AuthUrl, p.Withings.ClientId, p.Withings.ClientSecret, p.Withings.TokenFile)
+}
+
+// init系のモードは対話的に1アカウントずつ行うので、プロファイルを1つに絞る
+func select_single_profile(conf config, name string) (*profile, error) {
+    profiles, err := conf.SelectProfiles(name)
+    if err != nil {
+        return nil, err
+    }
+    if len(profiles) != 1 {
+        var names []string
+        for _, p := range profiles {
+            names = append(names, p.Name)
+        }
+        return nil, errors.New(fmt.Sprintf("Please set profile with -p. Profiles are %s", names))
+    }
+    return &profiles[0], nil
+}
+
+func run_init_healthplanet(conf config, profile_name string) error {
+    p, err := select_single_profile(conf, profile_name)
+    if err != nil {
+        return err
+    }
+    err = os.MkdirAll(filepath.Dir(p.HealthPlanet.TokenFile), 0700)
+    if err != nil {
+        return err
+    }
+    hp_auth := get_healthplanet_auth(*p)
+    err = hp_auth.InitToken()
     if err != nil {
         return err
     }
     return nil
 }
 
-func run_init_fitbit(conf config) error {
-    fb_auth := get_fitbit_auth(conf)
-    err := fb_auth.InitToken()
+func run_init_fitbit(conf config, profile_name string) error {
+    p, err := select_single_profile(conf, profile_name)
+    if err != nil {
+        return err
+    }
+    err = os.MkdirAll(filepath.Dir(p.Fitbit.TokenFile), 0700)
+    if err != nil {
+        return err
+    }
+    fb_auth := get_fitbit_auth(*p)
+    err = fb_auth.InitToken()
     if err != nil {
         return err
     }
     return nil
 }
 
-func run_sync(conf config, dry bool) error {
-    hp_tz, err := time.LoadLocation(conf.HealthPlanet.Timezone)
+func run_init_withings(conf config, profile_name string) error {
+    p, err := select_single_profile(conf, profile_name)
     if err != nil {
         return err
     }
-    fb_tz, err := time.LoadLocation(conf.Fitbit.Timezone)
+    err = os.MkdirAll(filepath.Dir(p.Withings.TokenFile), 0700)
     if err != nil {
         return err
     }
+    wi_auth := get_withings_auth(*p)
+    err = wi_auth.InitToken()
+    if err != nil {
+        return err
+    }
+    return nil
+}
+
+// トークンを読み込み、必要であればリフレッシュしたクライアントを返す
+func get_healthplanet_client(p profile) (*health_planet.Client, error) {
+    hp_tz, err := time.LoadLocation(p.HealthPlanet.Timezone)
+    if err != nil {
+        return nil, err
+    }
 
-    hp_auth := get_healthplanet_auth(conf)
+    hp_auth := get_healthplanet_auth(p)
     err = hp_auth.LoadToken()
     if err != nil {
-        return err
+        return nil, err
     }
     err = hp_auth.RefreshToken()
     if err != nil {
-        return err
+        return nil, err
     }
-    hp := health_planet.NewClient("https://www.healthplanet.jp", hp_auth, Logger, hp_tz)
+    return health_planet.NewClient(p.HealthPlanet.Url, hp_auth, Logger.With("profile", p.Name), hp_tz), nil
+}
 
-    fb_auth := get_fitbit_auth(conf)
+func get_fitbit_client(p profile) (*fitbit.Client, error) {
+    fb_tz, err := time.LoadLocation(p.Fitbit.Timezone)
+    if err != nil {
+        return nil, err
+    }
+
+    fb_auth := get_fitbit_auth(p)
     err = fb_auth.LoadToken()
     if err != nil {
-        return err
+        return nil, err
     }
     err = fb_auth.RefreshToken()
+    if err != nil {
+        return nil, err
+    }
+    return fitbit.NewClient(p.Fitbit.Url, fb_auth, Logger.With("profile", p.Name), fb_tz), nil
+}
+
+func get_withings_client(p profile) (*withings.Client, error) {
+    wi_tz, err := time.LoadLocation(p.Withings.Timezone)
+    if err != nil {
+        return nil, err
+    }
+
+    wi_auth := get_withings_auth(p)
+    err = wi_auth.LoadToken()
+    if err != nil {
+        return nil, err
+    }
+    err = wi_auth.RefreshToken()
+    if err != nil {
+        return nil, err
+    }
+    return withings.NewClient(p.Withings.Url, wi_auth, Logger.With("profile", p.Name), wi_tz), nil
+}
+
+func get_source(p profile) (measurement.Source, error) {
+    switch p.Source {
+    case source_csv:
+        return csv_source.NewSource(*p.CSV, Logger.With("profile", p.Name))
+    case source_fitbit:
+        return get_fitbit_client(p)
+    }
+    return get_healthplanet_client(p)
+}
+
+func get_sink(p profile, name string) (measurement.Sink, error) {
+    switch name {
+    case sink_fitbit:
+        return get_fitbit_client(p)
+    case sink_withings:
+        return get_withings_client(p)
+    case sink_influxdb:
+        return influxdb.NewSink(*p.InfluxDB, Logger.With("profile", p.Name)), nil
+    case sink_webhook:
+        return webhook.NewSink(*p.Webhook, Logger.With("profile", p.Name))
+    case sink_mqtt:
+        return mqtt.NewSink(*p.MQTT, Logger.With("profile", p.Name))
+    }
+    return nil, errors.New(fmt.Sprintf("Unknown sink: %s", name))
+}
+
+// 準備に失敗した送信先は失敗の結果として返し、残りの送信先だけで同期する
+func get_sinks(p profile) ([]measurement.Sink, []*sinkResult) {
+    var sinks []measurement.Sink
+    var failed []*sinkResult
+    for _, name := range p.Sinks {
+        sink, err := get_sink(p, name)
+        if err != nil {
+            Logger.Error(fmt.Sprintf("Prepare %s failed: %s", name, err))
+            failed = append(failed, &sinkResult{Sink: name, Err: err})
+            continue
+        }
+        sinks = append(sinks, sink)
+    }
+    return sinks, failed
+}
+
+// 送信先にFitbitがあればそのクライアントを使う(トークンのリフレッシュを1回で済ませるため)
+func find_fitbit_sink(p profile, sinks []measurement.Sink) (*fitbit.Client, error) {
+    for _, sink := range sinks {
+        if fb, ok := sink.(*fitbit.Client); ok {
+            return fb, nil
+        }
+    }
+    return get_fitbit_client(p)
+}
+
+func run_sync_profile(p profile, dry bool) error {
+    src, err := get_source(p)
     if err != nil {
         return err
     }
-    fb := fitbit.NewClient("https://api.fitbit.com", fb_auth, Logger, fb_tz)
+    sinks, results := get_sinks(p)
 
-    syncr := NewSyncr(hp, fb)
-    err = syncr.Sync(dry)
+    state, err := load_state(p.StateFile)
+    if err != nil {
+        return err
+    }
+    q, err := load_quarantine(p.QuarantineFile)
     if err != nil {
         return err
     }
 
+    var rejected []*quarantineEntry
+    if len(sinks) > 0 {
+        syncr := NewSyncr(src, sinks, state, q, p.Sync)
+        for _, r := range results {
+            syncr.Unprepared = append(syncr.Unprepared, r.Sink)
+        }
+        sync_result, err := syncr.Sync(dry)
+        if err != nil {
+            return err
+        }
+        results = append(sync_result.Sinks, results...)
+        rejected = sync_result.Rejected
+    }
+
+    var failed []string
+    if len(p.Sinks) > 1 {
+        fmt.Println("Result:")
+    }
+    for _, r := range results {
+        if len(p.Sinks) > 1 {
+            fmt.Printf("  %s\n", r)
+        }
+        if r.Err != nil {
+            failed = append(failed, r.Sink)
+        }
+    }
+    if len(rejected) > 0 && dry {
+        // dry-syncでは保存しないので、承認できるのはsyncで保存した後
+        fmt.Printf("%d measurement(s) would be quarantined. Run sync to save them to %s before approving\n", len(rejected), p.QuarantineFile)
+    } else if len(rejected) > 0 {
+        fmt.Printf("%d measurement(s) quarantined in %s. Check with -m quarantine, and approve with -m approve -id <id>\n", len(rejected), p.QuarantineFile)
+    }
+
+    // 歩数計の同期は体組成の送信先の成否に関わらず行う
+    if p.Sync.Pedometer {
+        hp, ok := src.(*health_planet.Client)
+        if !ok {
+            return errors.New("Pedometer sync needs health_planet source")
+        }
+        fb, err := find_fitbit_sink(p, sinks)
+        if err != nil {
+            return err
+        }
+        err = sync_pedometer(hp, fb, dry)
+        if err != nil {
+            return err
+        }
+    }
+
+    if len(failed) > 0 {
+        return errors.New(fmt.Sprintf("Failed sinks: %s", strings.Join(failed, ", ")))
+    }
+    return nil
+}
+
+// 1つのプロファイルが失敗しても残りのプロファイルは同期する
+func run_sync(conf config, profile_name string, dry bool) error {
+    profiles, err := conf.SelectProfiles(profile_name)
+    if err != nil {
+        return err
+    }
+
+    var failed []string
+    for _, p := range profiles {
+        if len(profiles) > 1 {
+            fmt.Printf("[%s]\n", p.Name)
+        }
+        err := run_sync_profile(p, dry)
+        if err != nil {
+            Logger.Error(fmt.Sprintf("Sync profile %s failed: %s", p.Name, err))
+            failed = append(failed, p.Name)
+        }
+    }
+
+    if len(failed) > 0 {
+        return errors.New(fmt.Sprintf("Failed profiles: %s", strings.Join(failed, ", ")))
+    }
     return nil
 }
 
@@ -168,33 +386,113 @@ func main() {
     }
 
     // Load config
-    conf, err := load_config()
+    conf, err := load_config(args.config_path)
     if err != nil {
         Logger.Error(fmt.Sprintf("Load config failed: %s", err))
         os.Exit(2)
     }
+    if args.token_dir != "" {
+        conf.TokenDir = args.token_dir
+    }
+    Logger.Debug(fmt.Sprintf("Config: %s, Token directory: %s", args.config_path, conf.TokenDir))
+
+    err = conf.Validate(args.profile, mode_scope(args.mode, args.provider))
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(3)
+    }
+    if args.mode == "check-config" {
+        fmt.Printf("Config OK: %s\n", args.config_path)
+        return
+    }
 
     if args.mode == "init_healthplanet" {
-        err := run_init_healthplanet(*conf)
+        err := run_init_healthplanet(*conf, args.profile)
         if err != nil {
             Logger.Error(fmt.Sprintf("Init HealthPlanet failed: %s", err))
             os.Exit(10)
         }
     }else if args.mode == "init_fitbit" {
-        err := run_init_fitbit(*conf)
+        err := run_init_fitbit(*conf, args.profile)
         if err != nil {
             Logger.Error(fmt.Sprintf("Init Fitbit failed: %s", err))
             os.Exit(11)
         }
+    }else if args.mode == "init_withings" {
+        err := run_init_withings(*conf, args.profile)
+        if err != nil {
+            Logger.Error(fmt.Sprintf("Init Withings failed: %s", err))
+            os.Exit(21)
+        }
     }else if (args.mode == "sync") {
-        err := run_sync(*conf, false)
+        err := run_sync(*conf, args.profile, false)
         if err != nil {
             Logger.Error(fmt.Sprintf("Sync failed: %s", err))
             os.Exit(12)
         }
         fmt.Println("Sync success")
+    }else if (args.mode == "doctor") {
+        err := run_doctor(*conf, args.profile)
+        if err != nil {
+            Logger.Error(fmt.Sprintf("Doctor: %s", err))
+            os.Exit(14)
+        }
+    }else if (args.mode == "token-status") {
+        err := run_token_status(*conf, args.profile, args.provider)
+        if err != nil {
+            Logger.Error(fmt.Sprintf("Token status failed: %s", err))
+            os.Exit(15)
+        }
+    }else if (args.mode == "refresh-token") {
+        err := run_refresh_token(*conf, args.profile, args.provider)
+        if err != nil {
+            Logger.Error(fmt.Sprintf("Refresh token failed: %s", err))
+            os.Exit(16)
+        }
+    }else if (args.mode == "logout") {
+        err := run_logout(*conf, args.profile, args.provider, args.archive)
+        if err != nil {
+            Logger.Error(fmt.Sprintf("Logout failed: %s", err))
+            os.Exit(17)
+        }
+    }else if (args.mode == "bp-export") {
+        err := run_bp_export(*conf, args.profile, args.export)
+        if err != nil {
+            Logger.Error(fmt.Sprintf("Blood pressure export failed: %s", err))
+            os.Exit(18)
+        }
+    }else if (args.mode == "export") {
+        err := run_export(*conf, args.profile, args.export)
+        if err != nil {
+            Logger.Error(fmt.Sprintf("Export failed: %s", err))
+            os.Exit(19)
+        }
+    }else if (args.mode == "fitbit-export") {
+        err := run_fitbit_export(*conf, args.profile, args.export)
+        if err != nil {
+            Logger.Error(fmt.Sprintf("Fitbit export failed: %s", err))
+            os.Exit(20)
+        }
+    }else if (args.mode == "quarantine") {
+        err := run_quarantine_list(*conf, args.profile)
+        if err != nil {
+            Logger.Error(fmt.Sprintf("Quarantine failed: %s", err))
+            os.Exit(22)
+        }
+    }else if (args.mode == "approve") {
+        err := run_approve(*conf, args.profile, args.id)
+        if err != nil {
+            Logger.Error(fmt.Sprintf("Approve failed: %s", err))
+            os.Exit(23)
+        }
+    }else if (args.mode == "reject") {
+        err := run_reject(*conf, args.profile, args.id)
+        if err != nil {
+            Logger.Error(fmt.Sprintf("Reject failed: %s", err))
+            os.Exit(24)
+        }
     }else if (args.mode == "dry-sync") {
-        err := run_sync(*conf, true)
+        err := run_sync(*conf, args.profile, true)
         if err != nil {
             Logger.Error(fmt.Sprintf("Dry sync failed: %s", err))
             os.Exit(13)
@@ -0,0 +1,13 @@
+package main
+
+import (
+    "io"
+    "log/slog"
+    "os"
+    "testing"
+)
+
+func TestMain(m *testing.M) {
+    Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+    os.Exit(m.Run())
+}
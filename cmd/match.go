@@ -0,0 +1,188 @@
+package main
+
+import (
+    "math"
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+// 取得元と送信先の記録を同じ測定とみなす日時の差と体重の差
+// (HealthPlanetは分単位、Fitbitは秒単位で、他のアプリ経由の記録は数十秒ずれることがある)
+const default_match_window = 2 * time.Minute
+const default_match_weight_tolerance = 0.1
+
+// match_windowが負の場合は日時が完全に一致する場合だけ同じ測定とみなす
+func (sc *syncConfig) match_window() time.Duration {
+    if sc.MatchWindow < 0 {
+        return 0
+    }
+    if sc.MatchWindow == 0 {
+        return default_match_window
+    }
+    return time.Duration(sc.MatchWindow) * time.Second
+}
+
+func (sc *syncConfig) match_weight_tolerance() float64 {
+    if sc.MatchWeightTolerance <= 0 {
+        return default_match_weight_tolerance
+    }
+    return sc.MatchWeightTolerance
+}
+
+// locでの現地時刻 (タイムゾーンを除いた日時)
+func wall_clock(t time.Time, loc *time.Location) time.Time {
+    l := t.In(loc)
+    return time.Date(l.Year(), l.Month(), l.Day(), l.Hour(), l.Minute(), l.Second(), l.Nanosecond(), time.UTC)
+}
+
+func abs_duration(d time.Duration) time.Duration {
+    if d < 0 {
+        return -d
+    }
+    return d
+}
+
+// 送信先の記録skdが取得元の測定sdと同じものか
+// 日時が一致する場合は値に関わらず同じ測定とみなす
+// Fitbitのように現地時刻で記録する送信先は、夏時間が終わる日の同じ現地時刻が2回ある時間帯を
+// 1時間ずれた時刻として読み込むことがあるので、絶対時刻の差と現地時刻の差の小さい方で比べる
+func (sc *syncConfig) is_same_measurement(sd measurement.BodyMeasurement, skd measurement.BodyMeasurement, loc *time.Location) bool {
+    if sd.Date.Equal(skd.Date) {
+        return true
+    }
+
+    d := abs_duration(sd.Date.Sub(skd.Date))
+    if wd := abs_duration(wall_clock(sd.Date, loc).Sub(wall_clock(skd.Date, loc))); wd < d {
+        d = wd
+    }
+    if d > sc.match_window() {
+        return false
+    }
+    return math.Abs(sd.Weight - skd.Weight) <= sc.match_weight_tolerance()
+}
+
+func sink_location(sink measurement.Sink, date time.Time) *time.Location {
+    if l, ok := sink.(measurement.Located); ok {
+        return l.Location()
+    }
+    return date.Location()
+}
+
+// 送信先の記録を日付(送信先のタイムゾーン)ごとに取得して使い回す
+// Dayのdateは送信先のタイムゾーンに変換してから渡す
+type existingCache struct {
+    sink measurement.Sink
+    days map[string][]measurement.BodyMeasurement
+}
+
+func new_existing_cache(sink measurement.Sink) *existingCache {
+    return &existingCache{sink: sink, days: make(map[string][]measurement.BodyMeasurement)}
+}
+
+func (c *existingCache) Day(date time.Time) ([]measurement.BodyMeasurement, error) {
+    key := date.Format("2006-01-02")
+    if data, ok := c.days[key]; ok {
+        return data, nil
+    }
+    data, err := c.sink.Existing(date)
+    if err != nil {
+        return nil, err
+    }
+    c.days[key] = data
+    return data, nil
+}
+
+// from〜toの日付(locのタイムゾーン)の記録をまとめて取得する
+// まとめて取得できない送信先は何もしない (Dayで1日ずつ取得する)
+func (c *existingCache) Prefetch(from time.Time, to time.Time, loc *time.Location) error {
+    rs, ok := c.sink.(measurement.RangeSink)
+    if !ok {
+        return nil
+    }
+    from = from.In(loc)
+    to = to.In(loc)
+    data, err := rs.ExistingRange(from, to)
+    if err != nil {
+        return err
+    }
+
+    // 記録が無い日も取得済みとして扱う
+    days := make(map[string][]measurement.BodyMeasurement)
+    for d := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc); day_key(d) <= day_key(to); d = d.AddDate(0, 0, 1) {
+        days[day_key(d)] = []measurement.BodyMeasurement{}
+    }
+    for _, m := range data {
+        key := day_key(m.Date.In(loc))
+        days[key] = append(days[key], m)
+    }
+    for key, ms := range days {
+        if _, ok := c.days[key]; !ok {
+            c.days[key] = ms
+        }
+    }
+    return nil
+}
+
+// dateの前後windowに記録された可能性のある送信先の記録を返す
+// (前後windowが日付をまたぐ場合は両方の日の記録を返す)
+func (c *existingCache) Around(date time.Time, window time.Duration, loc *time.Location) ([]measurement.BodyMeasurement, error) {
+    first := date.Add(-window).In(loc)
+    last := date.Add(window).In(loc)
+
+    ret, err := c.Day(first)
+    if err != nil {
+        return nil, err
+    }
+    if first.Format("2006-01-02") != last.Format("2006-01-02") {
+        data, err := c.Day(last)
+        if err != nil {
+            return nil, err
+        }
+        ret = append(append([]measurement.BodyMeasurement{}, ret...), data...)
+    }
+    return ret, nil
+}
+
+// Fitbitに同じ測定が無い場合でも、他のアプリ経由の記録との重複を避けるための方針
+// Fitbitの記録のsource("API"/"Aria"/"Web")と体重で判断する
+const (
+    // 同じ測定が無ければ送信する
+    duplicate_always = "always"
+    // 同じ日に体重の近い記録があれば送信しない
+    duplicate_same_day_weight = "same_day_weight"
+    // 同じ日にAPI経由の記録(Fitbitのsourceが"API")があれば送信しない
+    duplicate_no_api_entry = "no_api_entry"
+)
+
+var support_duplicate_policies = []string{duplicate_always, duplicate_same_day_weight, duplicate_no_api_entry}
+
+const default_duplicate_weight_tolerance = 0.2
+
+func (sc *syncConfig) duplicate_policy() string {
+    return default_string(sc.FitbitDuplicatePolicy, duplicate_always)
+}
+
+func (sc *syncConfig) duplicate_weight_tolerance() float64 {
+    if sc.FitbitDuplicateWeightTolerance <= 0 {
+        return default_duplicate_weight_tolerance
+    }
+    return sc.FitbitDuplicateWeightTolerance
+}
+
+// 同じ日のFitbitの記録から、方針により重複とみなす記録を返す (無い場合はnil)
+// api_sourceはAPI経由の記録の書き込み元 (measurement.SourcedSinkのWriteSource)
+func (sc *syncConfig) find_duplicate(sd measurement.BodyMeasurement, day_data []measurement.BodyMeasurement, api_source string) *measurement.BodyMeasurement {
+    for i, skd := range day_data {
+        switch sc.duplicate_policy() {
+        case duplicate_same_day_weight:
+            if math.Abs(sd.Weight - skd.Weight) <= sc.duplicate_weight_tolerance() {
+                return &day_data[i]
+            }
+        case duplicate_no_api_entry:
+            if skd.Source == api_source {
+                return &day_data[i]
+            }
+        }
+    }
+    return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+    "testing"
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+func TestIsSameMeasurement(t *testing.T) {
+    ny, err := time.LoadLocation("America/New_York")
+    if err != nil {
+        t.Fatal(err)
+    }
+    jst := time.FixedZone("JST", 9 * 60 * 60)
+    // 2024-11-03は夏時間が終わり、1:00〜2:00(現地時刻)が2回ある
+    // time.Dateではどちらの時刻になるか決まらないので、UTCから作る
+    edt := func(h int, m int, s int) time.Time { return time.Date(2024, 11, 3, h + 4, m, s, 0, time.UTC).In(ny) }
+    est := func(h int, m int, s int) time.Time { return time.Date(2024, 11, 3, h + 5, m, s, 0, time.UTC).In(ny) }
+    fixed := func(h int, m int, s int) time.Time { return time.Date(2024, 1, 2, h, m, s, 0, jst) }
+
+    tests := []struct {
+        name string
+        conf syncConfig
+        loc *time.Location
+        src time.Time
+        sink time.Time
+        src_weight float64
+        sink_weight float64
+        want bool
+    }{
+        {"same time", syncConfig{}, jst, fixed(7, 0, 0), fixed(7, 0, 0), 60, 70, true},
+        {"within window", syncConfig{}, jst, fixed(7, 0, 0), fixed(7, 1, 30), 60, 60, true},
+        {"window boundary", syncConfig{}, jst, fixed(7, 0, 0), fixed(7, 2, 0), 60, 60, true},
+        {"outside window", syncConfig{}, jst, fixed(7, 0, 0), fixed(7, 2, 1), 60, 60, false},
+        {"before window", syncConfig{}, jst, fixed(7, 0, 0), fixed(6, 57, 59), 60, 60, false},
+        {"custom window", syncConfig{MatchWindow: 600}, jst, fixed(7, 0, 0), fixed(7, 9, 0), 60, 60, true},
+        // 負の場合は日時が完全に一致する場合だけ
+        {"exact only", syncConfig{MatchWindow: -1}, jst, fixed(7, 0, 0), fixed(7, 0, 1), 60, 60, false},
+        {"weight within tolerance", syncConfig{}, jst, fixed(7, 0, 0), fixed(7, 0, 30), 60, 60.05, true},
+        {"weight outside tolerance", syncConfig{}, jst, fixed(7, 0, 0), fixed(7, 0, 30), 60, 60.2, false},
+        {"custom weight tolerance", syncConfig{MatchWeightTolerance: 0.5}, jst, fixed(7, 0, 0), fixed(7, 0, 30), 60, 60.4, true},
+        // 固定オフセットでは現地時刻の差と絶対時刻の差は同じ
+        {"fixed offset other day", syncConfig{}, jst, fixed(23, 59, 0), fixed(23, 59, 0).Add(90 * time.Second), 60, 60, true},
+
+        // 1回目の1:30(EDT)の測定を、Fitbitが2回目の1:30(EST)として読み込んだ場合: 絶対時刻では1時間、現地時刻では0
+        {"fall back same wall clock", syncConfig{}, ny, edt(1, 30, 0), est(1, 30, 0), 60, 60, true},
+        {"fall back near wall clock", syncConfig{}, ny, est(1, 31, 0), edt(1, 30, 0), 60, 60, true},
+        {"fall back outside window", syncConfig{}, ny, edt(1, 30, 0), est(1, 35, 0), 60, 60, false},
+        // 1:59(EDT)と1:00(EST)は現地時刻では59分離れているが、絶対時刻の差は1分
+        {"fall back across the switch", syncConfig{}, ny, edt(1, 59, 0), est(1, 0, 0), 60, 60, true},
+        {"fall back weight differs", syncConfig{}, ny, edt(1, 30, 0), est(1, 30, 0), 60, 61, false},
+        // 送信先のタイムゾーンで現地時刻を比べる (UTCでは1時間の差)
+        {"fall back in utc", syncConfig{}, time.UTC, edt(1, 30, 0), est(1, 30, 0), 60, 60, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            src := measurement.BodyMeasurement{Date: tt.src, Weight: tt.src_weight}
+            sink := measurement.BodyMeasurement{Date: tt.sink, Weight: tt.sink_weight}
+            if got := tt.conf.is_same_measurement(src, sink, tt.loc); got != tt.want {
+                t.Errorf("is_same_measurement(%s, %s) = %v, want %v", tt.src, tt.sink, got, tt.want)
+            }
+            // 取得元と送信先を入れ替えても同じ
+            if got := tt.conf.is_same_measurement(sink, src, tt.loc); got != tt.want {
+                t.Errorf("is_same_measurement(%s, %s) = %v, want %v", tt.sink, tt.src, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestFindDuplicate(t *testing.T) {
+    date := time.Date(2024, 1, 2, 7, 0, 0, 0, time.UTC)
+    day := []measurement.BodyMeasurement{
+        {Date: date.Add(2 * time.Hour), Weight: 60.5, Source: "Aria"},
+        {Date: date.Add(12 * time.Hour), Weight: 61.5, Source: "API"},
+    }
+    tests := []struct {
+        name string
+        conf syncConfig
+        weight float64
+        want int // 重複とみなすday_dataの番号 (無い場合は-1)
+    }{
+        {"always", syncConfig{}, 60.5, -1},
+        {"same day weight", syncConfig{FitbitDuplicatePolicy: duplicate_same_day_weight}, 60.4, 0},
+        {"same day weight tolerance", syncConfig{FitbitDuplicatePolicy: duplicate_same_day_weight}, 60.25, -1},
+        {"same day weight other entry", syncConfig{FitbitDuplicatePolicy: duplicate_same_day_weight}, 61.6, 1},
+        {"custom tolerance", syncConfig{FitbitDuplicatePolicy: duplicate_same_day_weight, FitbitDuplicateWeightTolerance: 0.3}, 60.25, 0},
+        // 体重に関わらずAPI経由の記録があれば重複
+        {"no api entry", syncConfig{FitbitDuplicatePolicy: duplicate_no_api_entry}, 50, 1},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := tt.conf.find_duplicate(measurement.BodyMeasurement{Date: date, Weight: tt.weight}, day, "API")
+            if tt.want < 0 {
+                if got != nil {
+                    t.Errorf("duplicate = %s, want nil", got)
+                }
+                return
+            }
+            if got != &day[tt.want] {
+                t.Errorf("duplicate = %v, want %s", got, &day[tt.want])
+            }
+        })
+    }
+
+    // API経由の記録が無い日は重複にしない
+    conf := syncConfig{FitbitDuplicatePolicy: duplicate_no_api_entry}
+    if got := conf.find_duplicate(measurement.BodyMeasurement{Date: date, Weight: 60}, day[:1], "API"); got != nil {
+        t.Errorf("duplicate = %s, want nil", got)
+    }
+}
+
+// Fitbitと同じく書き込んだ記録の書き込み元が"API"になる送信先
+type fakeSourcedSink struct {
+    fakeSink
+}
+
+func (s *fakeSourcedSink) WriteSource() string {
+    return "API"
+}
+
+func (s *fakeSourcedSink) Write(m measurement.BodyMeasurement) error {
+    m.Source = s.WriteSource()
+    return s.fakeSink.Write(m)
+}
+
+func TestCompareDuplicatePolicy(t *testing.T) {
+    loc := time.FixedZone("JST", 9 * 60 * 60)
+    date := time.Date(2024, 1, 2, 7, 0, 0, 0, loc)
+    aria := measurement.BodyMeasurement{Date: date.Add(30 * time.Minute), Weight: 60.1, Source: "Aria"}
+    api := measurement.BodyMeasurement{Date: date.Add(12 * time.Hour), Weight: 65, Source: "API"}
+    morning := measurement.BodyMeasurement{Date: date, Weight: 60}
+    evening := measurement.BodyMeasurement{Date: date.Add(12 * time.Hour + 30 * time.Minute), Weight: 60.15}
+    next_day := measurement.BodyMeasurement{Date: date.AddDate(0, 0, 1), Weight: 60}
+
+    tests := []struct {
+        name string
+        policy string
+        sourced bool
+        records []measurement.BodyMeasurement
+        targets []measurement.BodyMeasurement
+        want []time.Time
+    }{
+        {"always", duplicate_always, true, []measurement.BodyMeasurement{aria}, []measurement.BodyMeasurement{morning}, []time.Time{morning.Date}},
+        {"same day weight", duplicate_same_day_weight, true, []measurement.BodyMeasurement{aria}, []measurement.BodyMeasurement{morning, next_day}, []time.Time{next_day.Date}},
+        {"no api entry with aria", duplicate_no_api_entry, true, []measurement.BodyMeasurement{aria}, []measurement.BodyMeasurement{morning}, []time.Time{morning.Date}},
+        {"no api entry with api", duplicate_no_api_entry, true, []measurement.BodyMeasurement{api}, []measurement.BodyMeasurement{morning, next_day}, []time.Time{next_day.Date}},
+        // この同期で送信するデータも同じ日の記録として扱う
+        {"same day weight in batch", duplicate_same_day_weight, true, nil, []measurement.BodyMeasurement{morning, evening}, []time.Time{morning.Date}},
+        {"no api entry in batch", duplicate_no_api_entry, true, nil, []measurement.BodyMeasurement{morning, evening, next_day}, []time.Time{morning.Date, next_day.Date}},
+        // 書き込み元を返さない送信先には方針を適用しない
+        {"not sourced", duplicate_same_day_weight, false, []measurement.BodyMeasurement{aria}, []measurement.BodyMeasurement{morning}, []time.Time{morning.Date}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var sink measurement.Sink
+            if tt.sourced {
+                sink = &fakeSourcedSink{fakeSink{loc: loc, records: tt.records, max_writes: -1}}
+            } else {
+                sink = &fakeSink{loc: loc, records: tt.records, max_writes: -1}
+            }
+            s := new_test_syncr(t, &fakeSource{}, sink, &syncConfig{FitbitDuplicatePolicy: tt.policy})
+
+            reached := make(map[string]bool)
+            add_data, err := s.compare(sink, new_existing_cache(sink), tt.targets, reached)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(add_data) != len(tt.want) {
+                t.Fatalf("add_data = %v, want %v", add_data, tt.want)
+            }
+            for i, w := range tt.want {
+                if !add_data[i].Date.Equal(w) {
+                    t.Errorf("add_data[%d] = %s, want %s", i, add_data[i].Date, w)
+                }
+            }
+            // 重複として送信しないデータも送信先に届いたものとして扱う
+            if len(reached) != len(tt.targets) - len(tt.want) {
+                t.Errorf("reached = %v", reached)
+            }
+        })
+    }
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+)
+
+const app_dir_name = "tanita_to_fitbit"
+
+func xdg_dir(env string, fallback string) string {
+    if dir := os.Getenv(env); filepath.IsAbs(dir) {
+        return dir
+    }
+    home, err := os.UserHomeDir()
+    if err != nil {
+        return "."
+    }
+    return filepath.Join(home, fallback)
+}
+
+func default_config_dir() string {
+    return filepath.Join(xdg_dir("XDG_CONFIG_HOME", ".config"), app_dir_name)
+}
+
+func default_data_dir() string {
+    return filepath.Join(xdg_dir("XDG_DATA_HOME", filepath.Join(".local", "share")), app_dir_name)
+}
+
+// 設定ファイルの探索順
+// -config > $TANITA_TO_FITBIT_CONFIG > ./config.json(従来の配置) > $XDG_CONFIG_HOME/tanita_to_fitbit/config.json
+func find_config_file(flag_path string) string {
+    if flag_path != "" {
+        return flag_path
+    }
+    if path := os.Getenv(env_name(env_prefix, "config")); path != "" {
+        return path
+    }
+    if _, err := os.Stat(config_file); err == nil {
+        return config_file
+    }
+    return filepath.Join(default_config_dir(), config_file)
+}
+
+// トークンの保存先の決定順
+// -token-dir > $TANITA_TO_FITBIT_TOKEN_DIR / config.jsonのtoken_dir > 設定ファイルと同じディレクトリ(従来の配置) > $XDG_DATA_HOME/tanita_to_fitbit
+func default_token_dir(config_path string) string {
+    if config_path == config_file {
+        return "."
+    }
+    if filepath.Dir(config_path) != default_config_dir() {
+        return filepath.Dir(config_path)
+    }
+    return default_data_dir()
+}
+
+func token_path(token_dir string, token_file string) string {
+    if filepath.IsAbs(token_file) {
+        return token_file
+    }
+    return filepath.Join(token_dir, token_file)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/health_planet"
+    "github.com/kamaboko123/tanita_to_fitbit/fitbit"
+)
+
+// 歩数計データは1日の累計なので、記録が確定した前日までを同期する
+func sync_pedometer(hp *health_planet.Client, fb *fitbit.Client, dry bool) error {
+    now := time.Now().In(hp.Timezone)
+    today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+    hp_steps, err := hp.GetPedometerData(today.AddDate(0, 0, -7), today.Add(-time.Second))
+    if err != nil {
+        return err
+    }
+    Logger.Debug(fmt.Sprintf("Get %d pedometer data from Health Planet", len(hp_steps)))
+
+    var add_data []*health_planet.PedometerData
+    for _, hps := range hp_steps {
+        if hps.Steps <= 0 {
+            continue
+        }
+        // Fitbit側の日付で00:00開始の記録として扱う
+        start := time.Date(hps.Date.Year(), hps.Date.Month(), hps.Date.Day(), 0, 0, 0, 0, fb.Timezone)
+
+        fb_activity, err := fb.GetActivityLog(start)
+        if err != nil {
+            return err
+        }
+
+        // このツールで記録したもの(00:00開始のWalk)があればスキップ
+        is_exist := false
+        for _, a := range fb_activity.Activities {
+            if a.ActivityId == fitbit.ActivityIdWalk && a.StartTime == start.Format("15:04") {
+                is_exist = true
+                break
+            }
+        }
+
+        if !is_exist {
+            add_data = append(add_data, hps)
+        }
+    }
+
+    fmt.Printf("Found %d new pedometer data\n", len(add_data))
+
+    for _, ad := range add_data {
+        fmt.Printf("new_pedometer_data: %s", ad)
+        if !dry {
+            start := time.Date(ad.Date.Year(), ad.Date.Month(), ad.Date.Day(), 0, 0, 0, 0, fb.Timezone)
+            err = fb.CreateWalkLog(start, walk_duration(ad.Steps), int64(ad.Steps), int64(ad.Calories))
+            if err != nil {
+                fmt.Println(": Failed")
+                return err
+            }
+            fmt.Println(": Success")
+        }
+        fmt.Printf("\n")
+    }
+
+    return nil
+}
+
+// 歩数計は歩いた時間を記録しないので、1分100歩として所要時間を見積もる
+func walk_duration(steps float64) time.Duration {
+    d := time.Duration(steps / 100 * float64(time.Minute))
+    if d < time.Minute {
+        return time.Minute
+    }
+    return d
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "io/ioutil"
+    "encoding/json"
+    "errors"
+    "strings"
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+// 同じ測定は次の同期でも取得されることがあるので、承認・却下した後も状態を変えて残しておく
+const (
+    // 承認待ち
+    quarantine_pending = "pending"
+    // 承認済み (次の同期で送信する)
+    quarantine_approved = "approved"
+    // 承認済みで送信済み
+    quarantine_synced = "synced"
+    // 却下 (送信しない)
+    quarantine_rejected = "rejected"
+    // 承認済みだが、1日分をまとめる方針でその日の他の測定を送信した
+    quarantine_skipped = "skipped"
+)
+
+// 確認で弾かれた測定
+type quarantine struct {
+    Entries []*quarantineEntry `json:"entries"`
+
+    path string
+    changed bool
+}
+
+type quarantineEntry struct {
+    Id string `json:"id"`
+    Measurement measurement.BodyMeasurement `json:"measurement"`
+    Reason string `json:"reason"`
+    Status string `json:"status"`
+    QuarantinedAt int64 `json:"quarantined_at"`
+}
+
+// 測定日時と機種で測定を区別する
+func quarantine_id(m measurement.BodyMeasurement) string {
+    if m.Model == "" {
+        return fmt.Sprintf("%d", m.Date.Unix())
+    }
+    return fmt.Sprintf("%d-%s", m.Date.Unix(), m.Model)
+}
+
+// ファイルが無い場合は空の状態を返す
+func load_quarantine(path string) (*quarantine, error) {
+    q := &quarantine{path: path}
+    data, err := ioutil.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return q, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    err = json.Unmarshal(data, q)
+    if err != nil {
+        return nil, err
+    }
+    return q, nil
+}
+
+// 変更が無い場合は書き込まない
+func (q *quarantine) Save() error {
+    if !q.changed {
+        return nil
+    }
+    err := measurement.SaveJSON(q.path, q)
+    if err != nil {
+        return err
+    }
+    q.changed = false
+    return nil
+}
+
+func (q *quarantine) Find(id string) *quarantineEntry {
+    for _, e := range q.Entries {
+        if e.Id == id {
+            return e
+        }
+    }
+    return nil
+}
+
+// すでに同じ測定がある場合は追加しない
+func (q *quarantine) Add(m measurement.BodyMeasurement, reason string) {
+    if q.Find(quarantine_id(m)) != nil {
+        return
+    }
+    q.Entries = append(q.Entries, &quarantineEntry{
+        Id: quarantine_id(m),
+        Measurement: m,
+        Reason: reason,
+        Status: quarantine_pending,
+        QuarantinedAt: time.Now().Unix(),
+    })
+    q.changed = true
+}
+
+func (q *quarantine) Approved() []measurement.BodyMeasurement {
+    var ret []measurement.BodyMeasurement
+    for _, e := range q.Entries {
+        if e.Status == quarantine_approved {
+            ret = append(ret, e.Measurement)
+        }
+    }
+    return ret
+}
+
+// idに一致する測定の状態をfromのいずれかからtoに変える
+// idが"all"の場合はfromの状態の全ての測定を変える
+func (q *quarantine) set_status(id string, from []string, to string) ([]*quarantineEntry, error) {
+    var ret []*quarantineEntry
+    for _, e := range q.Entries {
+        if contains(from, e.Status) && (id == "all" || e.Id == id) {
+            e.Status = to
+            ret = append(ret, e)
+        }
+    }
+    if len(ret) == 0 {
+        return nil, errors.New(fmt.Sprintf("No %s measurement: %s", strings.Join(from, "/"), id))
+    }
+    q.changed = true
+    return ret, nil
+}
+
+func (q *quarantine) Approve(id string) ([]*quarantineEntry, error) {
+    return q.set_status(id, []string{quarantine_pending, quarantine_rejected}, quarantine_approved)
+}
+
+func (q *quarantine) Reject(id string) ([]*quarantineEntry, error) {
+    return q.set_status(id, []string{quarantine_pending, quarantine_approved}, quarantine_rejected)
+}
+
+// 承認済みの測定の状態を変える (idsが空の場合は何もしない)
+func (q *quarantine) mark(ids []string, to string) {
+    for _, id := range ids {
+        q.set_status(id, []string{quarantine_approved}, to)
+    }
+}
+
+// 全ての送信先に届いた承認済みの測定を送信済みにする
+func (q *quarantine) MarkSynced(ids []string) {
+    q.mark(ids, quarantine_synced)
+}
+
+func (q *quarantine) MarkSkipped(ids []string) {
+    q.mark(ids, quarantine_skipped)
+}
+
+func (e *quarantineEntry) String() string {
+    m := e.Measurement
+    return fmt.Sprintf("%s  %s  weight: %.2fkg, fat: %.1f%%, model: %s  [%s] %s", e.Id, m.Date.Format("2006-01-02 15:04"), m.Weight, m.BodyFat, m.Model, e.Status, e.Reason)
+}
+
+func run_quarantine_list(conf config, profile_name string) error {
+    profiles, err := conf.SelectProfiles(profile_name)
+    if err != nil {
+        return err
+    }
+
+    for _, p := range profiles {
+        q, err := load_quarantine(p.QuarantineFile)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("[%s] %d measurement(s) in %s\n", p.Name, len(q.Entries), p.QuarantineFile)
+        for _, e := range q.Entries {
+            fmt.Printf("  %s\n", e)
+        }
+    }
+    return nil
+}
+
+// 承認した測定は次の同期で(確認をせずに)送信する
+func run_approve(conf config, profile_name string, id string) error {
+    if id == "" {
+        return errors.New("Please set measurement id with -id (or -id all)")
+    }
+    p, err := select_single_profile(conf, profile_name)
+    if err != nil {
+        return err
+    }
+    q, err := load_quarantine(p.QuarantineFile)
+    if err != nil {
+        return err
+    }
+
+    approved, err := q.Approve(id)
+    if err != nil {
+        return err
+    }
+    for _, e := range approved {
+        fmt.Printf("approved: %s\n", e)
+    }
+    return q.Save()
+}
+
+func run_reject(conf config, profile_name string, id string) error {
+    if id == "" {
+        return errors.New("Please set measurement id with -id (or -id all)")
+    }
+    p, err := select_single_profile(conf, profile_name)
+    if err != nil {
+        return err
+    }
+    q, err := load_quarantine(p.QuarantineFile)
+    if err != nil {
+        return err
+    }
+
+    rejected, err := q.Reject(id)
+    if err != nil {
+        return err
+    }
+    for _, e := range rejected {
+        fmt.Printf("rejected: %s\n", e)
+    }
+    return q.Save()
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+    "path/filepath"
+    "testing"
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+func new_test_quarantine(t *testing.T) *quarantine {
+    t.Helper()
+    q, err := load_quarantine(filepath.Join(t.TempDir(), "quarantine.json"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return q
+}
+
+func TestQuarantineId(t *testing.T) {
+    date := time.Date(2024, 1, 4, 8, 0, 0, 0, time.UTC)
+    if got := quarantine_id(measurement.BodyMeasurement{Date: date}); got != "1704355200" {
+        t.Errorf("id = %s", got)
+    }
+    if got := quarantine_id(measurement.BodyMeasurement{Date: date, Model: "01000144"}); got != "1704355200-01000144" {
+        t.Errorf("id = %s", got)
+    }
+}
+
+func TestQuarantineStatus(t *testing.T) {
+    base := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+    a := measurement.BodyMeasurement{Date: base, Weight: 80}
+    b := measurement.BodyMeasurement{Date: base.Add(time.Hour), Weight: 30}
+
+    tests := []struct {
+        name string
+        // 事前の状態にする操作
+        prepare func(q *quarantine)
+        op func(q *quarantine) error
+        want_a string
+        want_b string
+        fail bool
+    }{
+        {
+            name: "approve pending",
+            op: func(q *quarantine) error { _, err := q.Approve(quarantine_id(a)); return err },
+            want_a: quarantine_approved, want_b: quarantine_pending,
+        },
+        {
+            name: "reject all",
+            op: func(q *quarantine) error { _, err := q.Reject("all"); return err },
+            want_a: quarantine_rejected, want_b: quarantine_rejected,
+        },
+        {
+            name: "approve rejected",
+            prepare: func(q *quarantine) { q.Reject(quarantine_id(a)) },
+            op: func(q *quarantine) error { _, err := q.Approve(quarantine_id(a)); return err },
+            want_a: quarantine_approved, want_b: quarantine_pending,
+        },
+        {
+            name: "reject approved",
+            prepare: func(q *quarantine) { q.Approve("all") },
+            op: func(q *quarantine) error { _, err := q.Reject(quarantine_id(b)); return err },
+            want_a: quarantine_approved, want_b: quarantine_rejected,
+        },
+        {
+            name: "approve unknown id",
+            op: func(q *quarantine) error { _, err := q.Approve("123"); return err },
+            want_a: quarantine_pending, want_b: quarantine_pending, fail: true,
+        },
+        {
+            // 送信済みの測定は承認・却下し直さない
+            name: "reject synced",
+            prepare: func(q *quarantine) {
+                q.Approve(quarantine_id(a))
+                q.MarkSynced([]string{quarantine_id(a)})
+            },
+            op: func(q *quarantine) error { _, err := q.Reject(quarantine_id(a)); return err },
+            want_a: quarantine_synced, want_b: quarantine_pending, fail: true,
+        },
+        {
+            // 承認済みの測定だけ変える
+            name: "mark synced and skipped",
+            prepare: func(q *quarantine) { q.Approve(quarantine_id(a)) },
+            op: func(q *quarantine) error {
+                q.MarkSynced([]string{quarantine_id(b)})
+                q.MarkSkipped([]string{quarantine_id(a), quarantine_id(b)})
+                return nil
+            },
+            want_a: quarantine_skipped, want_b: quarantine_pending,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            q := new_test_quarantine(t)
+            q.Add(a, "weight above max")
+            q.Add(b, "weight below min")
+            if tt.prepare != nil {
+                tt.prepare(q)
+            }
+            err := tt.op(q)
+            if (err != nil) != tt.fail {
+                t.Errorf("err = %v", err)
+            }
+            if got := q.Find(quarantine_id(a)).Status; got != tt.want_a {
+                t.Errorf("a = %s, want %s", got, tt.want_a)
+            }
+            if got := q.Find(quarantine_id(b)).Status; got != tt.want_b {
+                t.Errorf("b = %s, want %s", got, tt.want_b)
+            }
+        })
+    }
+}
+
+func TestQuarantineAddSave(t *testing.T) {
+    q := new_test_quarantine(t)
+    m := measurement.BodyMeasurement{Date: time.Unix(1704355200, 0), Weight: 80, Model: "01000144"}
+    q.Add(m, "first")
+    q.Approve("all")
+    // 同じ測定は追加し直さない (承認済みのまま)
+    q.Add(m, "second")
+    if len(q.Entries) != 1 || q.Entries[0].Reason != "first" || q.Entries[0].Status != quarantine_approved {
+        t.Fatalf("entries = %v", q.Entries)
+    }
+    if got := q.Approved(); len(got) != 1 || got[0].Weight != 80 {
+        t.Errorf("approved = %v", got)
+    }
+
+    err := q.Save()
+    if err != nil {
+        t.Fatal(err)
+    }
+    loaded, err := load_quarantine(q.path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    e := loaded.Find(quarantine_id(m))
+    if e == nil || e.Status != quarantine_approved || !e.Measurement.Date.Equal(m.Date) || e.Measurement.Model != m.Model {
+        t.Errorf("loaded = %v", loaded.Entries)
+    }
+}
+
+// 確認待ち・却下した測定は送信せず、承認済みの測定は確認せずに送信する
+func TestSyncrFilter(t *testing.T) {
+    base := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+    var data []measurement.BodyMeasurement
+    for i, w := range []float64{60, 60.5, 61, 80, 59.5, 30} {
+        data = append(data, measurement.BodyMeasurement{Date: base.AddDate(0, 0, i), Weight: w})
+    }
+    // 前回の同期で弾かれて承認された、今回の取得範囲より前の測定
+    approved := measurement.BodyMeasurement{Date: base.AddDate(0, 0, -1), Weight: 90}
+
+    s := new_test_syncr(t, &fakeSource{}, &fakeSink{loc: time.UTC}, &syncConfig{Filter: &filterConfig{MinWeight: 40, MaxDeviation: 3}})
+    s.Quarantine.Add(approved, "test")
+    s.Quarantine.Approve("all")
+
+    accepted, rejected := s.filter(data)
+    if got := dates(accepted); len(got) != 5 || !accepted[0].Date.Equal(approved.Date) {
+        t.Errorf("accepted = %v", got)
+    }
+    if len(rejected) != 2 || rejected[0].Measurement.Weight != 80 || rejected[1].Measurement.Weight != 30 {
+        t.Fatalf("rejected = %v", rejected)
+    }
+    // 承認済みの測定も最近の測定に含む (90kgは中央値を変えない)
+    if got := s.State.RecentWeights(base.AddDate(0, 1, 0), 10); len(got) != 5 {
+        t.Errorf("recent = %v", got)
+    }
+
+    // 弾かれた測定は次の同期でも確認待ちのまま送信しない
+    accepted, rejected = s.filter(data)
+    if len(accepted) != 5 || len(rejected) != 0 {
+        t.Errorf("second filter: accepted = %v, rejected = %v", dates(accepted), rejected)
+    }
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+    "os"
+    "io/ioutil"
+    "encoding/json"
+    "errors"
+    "sort"
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+// 同期の進捗を保存するファイル
+type syncState struct {
+    // 取得元/送信先ごとの進捗
+    Sources map[string]*sourceState `json:"sources"`
+    // 外れ値の判定に使う最近の測定 (日時順)
+    Recent []recentMeasurement `json:"recent,omitempty"`
+
+    path string
+}
+
+type sourceState struct {
+    // この日時までに取得元で利用可能になったデータは同期済み
+    SyncedUntil int64 `json:"synced_until"`
+}
+
+type recentMeasurement struct {
+    Date int64 `json:"date"`
+    Weight float64 `json:"weight"`
+}
+
+// 最近の測定として残す件数
+const recent_history_size = 100
+
+// ファイルが無い場合は空の状態を返す
+func load_state(path string) (*syncState, error) {
+    st := &syncState{path: path}
+    data, err := ioutil.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return st, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    err = json.Unmarshal(data, st)
+    if err != nil {
+        return nil, err
+    }
+    return st, nil
+}
+
+func (st *syncState) Save() error {
+    return measurement.SaveJSON(st.path, st)
+}
+
+func (st *syncState) SyncedUntil(source string) time.Time {
+    ss, ok := st.Sources[source]
+    if !ok || ss.SyncedUntil == 0 {
+        return time.Time{}
+    }
+    return time.Unix(ss.SyncedUntil, 0)
+}
+
+func (st *syncState) SetSyncedUntil(source string, t time.Time) {
+    if st.Sources == nil {
+        st.Sources = make(map[string]*sourceState)
+    }
+    if _, ok := st.Sources[source]; !ok {
+        st.Sources[source] = &sourceState{}
+    }
+    st.Sources[source].SyncedUntil = t.Unix()
+}
+
+// 同じ日時の測定は1つだけ残す
+func (st *syncState) AddRecent(date time.Time, weight float64) {
+    for _, r := range st.Recent {
+        if r.Date == date.Unix() {
+            return
+        }
+    }
+    st.Recent = append(st.Recent, recentMeasurement{Date: date.Unix(), Weight: weight})
+    sort.SliceStable(st.Recent, func(i, j int) bool { return st.Recent[i].Date < st.Recent[j].Date })
+    if len(st.Recent) > recent_history_size {
+        st.Recent = st.Recent[len(st.Recent) - recent_history_size:]
+    }
+}
+
+// dateより前の最近n件の体重を返す
+func (st *syncState) RecentWeights(date time.Time, n int) []float64 {
+    var ret []float64
+    for _, r := range st.Recent {
+        if r.Date >= date.Unix() {
+            break
+        }
+        ret = append(ret, r.Weight)
+    }
+    if len(ret) > n {
+        ret = ret[len(ret) - n:]
+    }
+    return ret
+}
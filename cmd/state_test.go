@@ -0,0 +1,75 @@
+package main
+
+import (
+    "fmt"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestRecentWeights(t *testing.T) {
+    st := &syncState{}
+    base := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+    // 日時順でなくても日時順に保存する
+    for _, i := range []int{3, 0, 2, 1, 4} {
+        st.AddRecent(base.AddDate(0, 0, i), 60 + float64(i))
+    }
+    // 同じ日時は追加しない
+    st.AddRecent(base, 99)
+
+    tests := []struct {
+        date time.Time
+        n int
+        want []float64
+    }{
+        // dateより前だけ
+        {base.AddDate(0, 0, 3), 10, []float64{60, 61, 62}},
+        // 最近のn件
+        {base.AddDate(0, 0, 10), 2, []float64{63, 64}},
+        {base, 10, nil},
+    }
+    for _, tt := range tests {
+        got := st.RecentWeights(tt.date, tt.n)
+        if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+            t.Errorf("RecentWeights(%s, %d) = %v, want %v", tt.date, tt.n, got, tt.want)
+        }
+    }
+}
+
+func TestRecentHistorySize(t *testing.T) {
+    st := &syncState{}
+    base := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+    for i := 0; i < recent_history_size + 20; i++ {
+        st.AddRecent(base.Add(time.Duration(i) * time.Hour), float64(i))
+    }
+    if len(st.Recent) != recent_history_size || st.Recent[0].Weight != 20 {
+        t.Errorf("len = %d, first = %v", len(st.Recent), st.Recent[0])
+    }
+}
+
+func TestStateSaveLoad(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "state.json")
+    st, err := load_state(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !st.SyncedUntil("health_planet/fitbit").IsZero() {
+        t.Errorf("new state has synced_until")
+    }
+
+    now := time.Unix(time.Now().Unix(), 0)
+    st.SetSyncedUntil("health_planet/fitbit", now)
+    st.AddRecent(now, 60)
+    err = st.Save()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    loaded, err := load_state(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !loaded.SyncedUntil("health_planet/fitbit").Equal(now) || len(loaded.RecentWeights(now.Add(time.Second), 10)) != 1 {
+        t.Errorf("loaded = %+v", loaded)
+    }
+}
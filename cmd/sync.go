@@ -2,81 +2,433 @@ package main
 
 import (
     "fmt"
+    "math"
     "time"
-    "github.com/kamaboko123/tanita_to_fitbit/health_planet"
     "github.com/kamaboko123/tanita_to_fitbit/fitbit"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
 )
 
 type Syncr struct {
-    HealthPlanet *health_planet.Client
-    Fitbit *fitbit.Client
+    Source measurement.Source
+    Sinks []measurement.Sink
+    State *syncState
+    Quarantine *quarantine
+    Config *syncConfig
+    // 準備に失敗して同期しない送信先 (承認済みの測定を送信済みにしない)
+    Unprepared []string
 }
 
-type AddData struct {
-    Date time.Time
-    HealthPlanetData *health_planet.InnerscanData
+// 1回の同期の結果
+type syncResult struct {
+    Sinks []*sinkResult
+    // この同期で確認に弾かれて保存した測定
+    Rejected []*quarantineEntry
 }
 
-func NewSyncr(hp_client *health_planet.Client, fb_client *fitbit.Client) *Syncr {
-    return &Syncr{HealthPlanet: hp_client, Fitbit: fb_client}
+// 送信先ごとの同期結果
+type sinkResult struct {
+    Sink string
+    Found int
+    Written int
+    Err error
+    // 書き込んだ、または記録済みだった測定 (quarantine_id)
+    Reached map[string]bool
 }
 
-func (s *Syncr) Sync(dry bool) error {
-    // get latest data from health planet
-    hp_weight, err := s.HealthPlanet.GetInnerscanData()
+func (r *sinkResult) String() string {
+    if r.Err != nil && r.Found == 0 {
+        return fmt.Sprintf("%s: failed (%s)", r.Sink, r.Err)
+    }
+    if r.Err != nil {
+        return fmt.Sprintf("%s: failed after %d/%d written (%s)", r.Sink, r.Written, r.Found, r.Err)
+    }
+    return fmt.Sprintf("%s: %d/%d written", r.Sink, r.Written, r.Found)
+}
+
+// 身長を提供できる取得元・送信先 (BMIの確認に使う)
+type heightProvider interface {
+    Height() float64
+}
+
+// 前回の同期からこれだけ遡ったデータも取り直す(時計のずれ対策)
+const sync_overlap = time.Hour
+const default_sync_window = 7 * 24 * time.Hour
+
+func NewSyncr(source measurement.Source, sinks []measurement.Sink, state *syncState, q *quarantine, conf *syncConfig) *Syncr {
+    return &Syncr{Source: source, Sinks: sinks, State: state, Quarantine: q, Config: conf}
+}
+
+// 同期の進捗は取得元と送信先の組み合わせごとに記録する
+func (s *Syncr) state_key(sink measurement.Sink) string {
+    return s.Source.Name() + "/" + sink.Name()
+}
+
+// 前回の同期以降に取得元で利用可能になったデータを取得する範囲
+// 過去のデータを取り込む取得元の初回の同期は全ての期間(ゼロ値から)を取得する
+func (s *Syncr) sync_range(sink measurement.Sink, now time.Time) time.Time {
+    last := s.State.SyncedUntil(s.state_key(sink))
+    if last.IsZero() {
+        if hs, ok := s.Source.(measurement.HistorySource); ok && hs.FullHistory() {
+            return time.Time{}
+        }
+        return now.Add(-default_sync_window)
+    }
+    return last.Add(-sync_overlap)
+}
+
+// 取得元からは1回だけ取得し、全ての送信先に書き込む
+// 送信先のエラーは結果に記録して残りの送信先の同期を続ける (エラーを返すのは取得元に失敗した場合のみ)
+func (s *Syncr) Sync(dry bool) (*syncResult, error) {
+    // get latest data from source
+    // HealthPlanetの場合は測定日時ではなく登録日時で絞り込むので、数日遅れてアップロードされたデータも取得できる
+    // 送信先ごとに進捗が違う場合は一番古いところから取得する (取得済みのデータは送信先との比較で除かれる)
+    now := time.Now()
+    from := now
+    for _, sink := range s.Sinks {
+        if f := s.sync_range(sink, now); f.Before(from) {
+            from = f
+        }
+    }
+    src_data, err := s.fetch(from, now)
     if err != nil {
-        return err
+        return nil, err
     }
-    Logger.Debug(fmt.Sprintf("Get %d data from Health Planet", len(hp_weight)))
-    Logger.Debug(fmt.Sprintf("Latest data: %s", hp_weight))
+    Logger.Debug(fmt.Sprintf("Get %d data from %s", len(src_data), s.Source.Name()))
 
-    var add_data []AddData
+    var targets []measurement.BodyMeasurement
+    for _, sd := range src_data {
+        Logger.Debug(fmt.Sprintf("[%s(expect)] %s", s.Source.Name(), &sd))
+        if !s.Config.IsModelTarget(sd.Model) {
+            Logger.Debug(fmt.Sprintf("Skip model: %s", sd.Model))
+            continue
+        }
+        targets = append(targets, sd)
+    }
 
-    // compare latest data
-    for _, hpw := range hp_weight {
-        Logger.Debug(fmt.Sprintf("[Health Planet(expect)] %s", hpw))
-        
-        // この日付のデータがすでにFitbitに存在するか確認
-        fb_weight_resp, err := s.Fitbit.GetWeightLog(hpw.Date)
+    // 外れ値を除いてから1日分をまとめる
+    ret := &syncResult{}
+    targets, ret.Rejected = s.filter(targets)
+    if len(ret.Rejected) > 0 {
+        fmt.Printf("Rejected %d data\n", len(ret.Rejected))
+        for _, e := range ret.Rejected {
+            fmt.Printf("rejected: %s (weight: %fkg, fat: %f%%, model: %s): %s\n", e.Measurement.Date, e.Measurement.Weight, e.Measurement.BodyFat, e.Measurement.Model, e.Reason)
+        }
+        fmt.Printf("\n")
+    }
+    targets, unselected := s.Config.aggregate(targets, now)
+
+    for _, sink := range s.Sinks {
+        if len(s.Sinks) > 1 {
+            fmt.Printf("-> %s\n", sink.Name())
+        }
+        result := s.sync_sink(sink, targets, now, dry)
+        if result.Err != nil {
+            Logger.Error(fmt.Sprintf("Sync to %s failed: %s", sink.Name(), result.Err))
+        }
+        ret.Sinks = append(ret.Sinks, result)
+    }
+
+    if !dry {
+        s.update_quarantine(unselected, ret.Sinks)
+        err = s.Quarantine.Save()
         if err != nil {
-            return err
+            return nil, err
         }
-        fb_weight, err := fb_weight_resp.ToWeightLog(s.Fitbit.Timezone)
+        err = s.State.Save()
         if err != nil {
-            return err
+            return nil, err
         }
+    }
 
-        Logger.Debug(fmt.Sprintf("[Fitbit(targets)] %s", fb_weight))
-        is_exist := false
-        for _, fbw := range fb_weight {
-            if hpw.Date.Equal(fbw.Date) {
-                // 日付が一致した場合はすでにデータが存在しているのでスキップ
-                is_exist = true
+    return ret, nil
+}
+
+// 承認済みの測定のうち、全ての送信先に届いたものを送信済みにする
+// 1日分をまとめる方針で他の測定が選ばれたものは送信しないので、選ばれなかったものにする
+// (どちらでもない測定は承認済みのまま次の同期で送信する)
+func (s *Syncr) update_quarantine(unselected []measurement.BodyMeasurement, results []*sinkResult) {
+    var synced []string
+    var skipped []string
+    for _, am := range s.Quarantine.Approved() {
+        id := quarantine_id(am)
+        reached := len(s.Unprepared) == 0
+        for _, r := range results {
+            if !r.Reached[id] {
+                reached = false
+            }
+        }
+        if reached {
+            synced = append(synced, id)
+            continue
+        }
+        for _, m := range unselected {
+            if quarantine_id(m) == id {
+                skipped = append(skipped, id)
                 break
             }
         }
+    }
+    s.Quarantine.MarkSynced(synced)
+    s.Quarantine.MarkSkipped(skipped)
+}
+
+// 取得元から測定データを取得する
+// 1日分をまとめる場合は、途中から取得した日の測定で選ばないように、取得した最初の日の0時から取得し直す
+// (HealthPlanetは登録日時で絞り込むので、fromより前に登録された同じ日の測定は最初の取得に含まれない)
+func (s *Syncr) fetch(from time.Time, now time.Time) ([]measurement.BodyMeasurement, error) {
+    if s.Config.daily_policy() == daily_all {
+        return s.Source.Measurements(from, now)
+    }
 
-        if !is_exist {
-            add_data = append(add_data, AddData{Date: hpw.Date, HealthPlanetData: hpw})
+    // 承認済みの測定の日も選び直す
+    for _, am := range s.Quarantine.Approved() {
+        if am.Date.Before(from) {
+            from = am.Date
         }
     }
+    // 日付は実行しているホストではなく取得元のタイムゾーンで区切る
+    loc := s.Source.Location()
+    from = start_of_day(from.In(loc))
+    for {
+        data, err := s.Source.Measurements(from, now)
+        if err != nil {
+            return nil, err
+        }
+        earliest := from
+        for _, m := range data {
+            if d := start_of_day(m.Date.In(loc)); d.Before(earliest) {
+                earliest = d
+            }
+        }
+        if !earliest.Before(from) {
+            return data, nil
+        }
+        Logger.Debug(fmt.Sprintf("Fetch again from %s to get all measurements of the day", earliest))
+        from = earliest
+    }
+}
+
+// 承認済みの測定を加え、確認に弾かれた測定を取り除く
+// 弾かれた測定は承認されるまで保存しておき、問題の無い測定は次回以降の外れ値の判定に使う
+func (s *Syncr) filter(targets []measurement.BodyMeasurement) ([]measurement.BodyMeasurement, []*quarantineEntry) {
+    data := append([]measurement.BodyMeasurement{}, targets...)
+    for _, am := range s.Quarantine.Approved() {
+        found := false
+        for _, t := range targets {
+            if quarantine_id(t) == quarantine_id(am) {
+                found = true
+                break
+            }
+        }
+        if !found {
+            data = append(data, am)
+        }
+    }
+    measurement.SortByDate(data)
 
-    fmt.Printf("Found %d new data\n", len(add_data))
+    var accepted []measurement.BodyMeasurement
+    var rejected []*quarantineEntry
+    for _, m := range data {
+        if e := s.Quarantine.Find(quarantine_id(m)); e != nil {
+            if e.Status == quarantine_pending || e.Status == quarantine_rejected {
+                Logger.Debug(fmt.Sprintf("Skip %s measurement: %s", e.Status, e.Id))
+                continue
+            }
+            // 承認済みの測定は確認しない
+            accepted = append(accepted, m)
+            s.State.AddRecent(m.Date, m.Weight)
+            continue
+        }
 
-    for _, ad := range add_data {
-        fmt.Printf("new_data: %s (weight: %fkg, fat: %f%%)", ad.Date, ad.HealthPlanetData.Weight, ad.HealthPlanetData.BodyFat)
-        if !dry {
-            err = s.Fitbit.CreateWeightAndFatLog(ad.Date, ad.HealthPlanetData.Weight, ad.HealthPlanetData.BodyFat)
+        if f := s.Config.Filter; f != nil {
+            if reason := f.Check(m, s.State.RecentWeights(m.Date, f.median_count())); reason != "" {
+                s.Quarantine.Add(m, reason)
+                rejected = append(rejected, s.Quarantine.Find(quarantine_id(m)))
+                continue
+            }
+        }
+        accepted = append(accepted, m)
+        s.State.AddRecent(m.Date, m.Weight)
+    }
+    return accepted, rejected
+}
+
+// 送信先と比較して書き込む測定の日数の単位
+// (Fitbitの記録を1回で取得できる日数。過去の記録をまとめて送信する場合も、失敗するまでに書き込んだ分は進捗に残る)
+const sync_batch_days = fitbit.MaxWeightLogRange
+
+// 測定日(送信先のタイムゾーン)がsync_batch_days日に収まるように分ける (targetsは日時順)
+func split_batches(sink measurement.Sink, targets []measurement.BodyMeasurement) [][]measurement.BodyMeasurement {
+    var batches [][]measurement.BodyMeasurement
+    var first time.Time
+    for _, sd := range targets {
+        day := wall_clock(sd.Date, sink_location(sink, sd.Date)).Truncate(24 * time.Hour)
+        if len(batches) == 0 || day.Sub(first) >= sync_batch_days * 24 * time.Hour {
+            batches = append(batches, nil)
+            first = day
+        }
+        batches[len(batches) - 1] = append(batches[len(batches) - 1], sd)
+    }
+    return batches
+}
+
+// 送信先に無いデータを書き込み、最後まで成功した場合だけ進捗を進める
+// (途中で失敗した場合は次回同じ範囲から比較し直すので、書き込み済みのデータは重複しない)
+// 複数の単位に分かれる場合は、単位ごとに書き込んだ最後の測定日時まで進捗を保存する
+func (s *Syncr) sync_sink(sink measurement.Sink, targets []measurement.BodyMeasurement, now time.Time, dry bool) *sinkResult {
+    result := &sinkResult{Sink: sink.Name(), Reached: make(map[string]bool)}
+    cache := new_existing_cache(sink)
+    checked_height := false
+
+    batches := split_batches(sink, targets)
+    if len(batches) == 0 {
+        batches = append(batches, nil)
+    }
+    for _, batch := range batches {
+        if len(batches) > 1 {
+            fmt.Printf("[%s - %s]\n", batch[0].Date.Format("2006-01-02"), batch[len(batch) - 1].Date.Format("2006-01-02"))
+        }
+        add_data, err := s.compare(sink, cache, batch, result.Reached)
+        if err != nil {
+            result.Err = err
+            return result
+        }
+
+        result.Found += len(add_data)
+        fmt.Printf("Found %d new data\n", len(add_data))
+        if len(add_data) > 0 && !checked_height {
+            s.check_height(sink)
+            checked_height = true
+        }
+
+        for _, ad := range add_data {
+            fmt.Printf("new_data: %s (weight: %fkg, fat: %f%%, bmi: %.1f, model: %s)", ad.Date, ad.Weight, ad.BodyFat, ad.BMI, ad.Model)
+            if !dry {
+                err := sink.Write(ad)
+                if err != nil {
+                    fmt.Println(": Failed")
+                    result.Err = err
+                    return result
+                }
+                result.Written++
+                result.Reached[quarantine_id(ad)] = true
+                fmt.Println(": Success")
+            }
+            fmt.Printf("\n")
+        }
+
+        if !dry && len(batches) > 1 {
+            key := s.state_key(sink)
+            if last := batch[len(batch) - 1].Date; last.After(s.State.SyncedUntil(key)) {
+                // 測定日時は取得元で利用可能になった日時より前なので、ここまでは同期済みとしてよい
+                s.State.SetSyncedUntil(key, last)
+                err := s.State.Save()
+                if err != nil {
+                    result.Err = err
+                    return result
+                }
+            }
+        }
+    }
+
+    if !dry {
+        s.State.SetSyncedUntil(s.state_key(sink), now)
+        err := s.State.Save()
+        if err != nil {
+            result.Err = err
+        }
+    }
+
+    return result
+}
+
+// 送信先に無いデータを返す (記録済みのデータはreachedに加える)
+func (s *Syncr) compare(sink measurement.Sink, cache *existingCache, targets []measurement.BodyMeasurement, reached map[string]bool) ([]measurement.BodyMeasurement, error) {
+    if len(targets) == 0 {
+        return nil, nil
+    }
+
+    var add_data []measurement.BodyMeasurement
+    window := s.Config.match_window()
+
+    // まとめて取得できる送信先は比較する範囲の記録を先に取得しておく
+    err := cache.Prefetch(targets[0].Date.Add(-window), targets[len(targets) - 1].Date.Add(window), sink_location(sink, targets[0].Date))
+    if err != nil {
+        return nil, err
+    }
+
+    // compare latest data
+    for _, sd := range targets {
+        // 前後match_windowの間に送信先で記録されたデータを確認
+        loc := sink_location(sink, sd.Date)
+        sink_data, err := cache.Around(sd.Date, window, loc)
+        if err != nil {
+            return nil, err
+        }
+
+        Logger.Debug(fmt.Sprintf("[%s(targets)] %v", sink.Name(), sink_data))
+        is_exist := false
+        for _, skd := range sink_data {
+            if s.Config.is_same_measurement(sd, skd, loc) {
+                // 同じ測定が記録済みなのでスキップ
+                Logger.Debug(fmt.Sprintf("Already exists in %s: %s", sink.Name(), &skd))
+                is_exist = true
+                break
+            }
+        }
+
+        if is_exist {
+            reached[quarantine_id(sd)] = true
+            continue
+        }
+
+        if ss, ok := sink.(measurement.SourcedSink); ok && s.Config.duplicate_policy() != duplicate_always {
+            day_data, err := cache.Day(sd.Date.In(loc))
             if err != nil {
-                fmt.Println(": Failed")
-                return err
+                return nil, err
+            }
+            // この同期で送信する同じ日のデータもAPI経由の記録として扱う (次回の同期と結果を揃えるため)
+            day_data = append([]measurement.BodyMeasurement{}, day_data...)
+            for _, ad := range add_data {
+                if ad.Date.In(loc).Format("2006-01-02") == sd.Date.In(loc).Format("2006-01-02") {
+                    ad.Source = ss.WriteSource()
+                    day_data = append(day_data, ad)
+                }
+            }
+            if dup := s.Config.find_duplicate(sd, day_data, ss.WriteSource()); dup != nil {
+                fmt.Printf("skip: %s (weight: %fkg), %s has %fkg at %s (source: %s) [%s]\n", sd.Date, sd.Weight, sink.Name(), dup.Weight, dup.Date.In(loc).Format("15:04:05"), dup.Source, s.Config.duplicate_policy())
+                reached[quarantine_id(sd)] = true
+                continue
             }
-            fmt.Println(": Success")
         }
-        fmt.Printf("\n")
+
+        add_data = append(add_data, sd)
     }
 
-    // add new data to fitbit
-    return nil
+    return add_data, nil
 }
 
+// 身長の許容誤差(cm)
+const height_tolerance = 1.0
+
+// FitbitはBMIをFitbitのプロフィールの身長から計算するので、
+// 取得元(HealthPlanet)の身長と異なる場合はタニタの体組成計とBMIがずれることを警告する
+func (s *Syncr) check_height(sink measurement.Sink) {
+    src, ok := s.Source.(heightProvider)
+    if !ok {
+        return
+    }
+    sp, ok := sink.(heightProvider)
+    if !ok {
+        return
+    }
+
+    src_height := src.Height()
+    sink_height := sp.Height()
+    if src_height <= 0 || sink_height <= 0 {
+        return
+    }
+    if math.Abs(src_height - sink_height) > height_tolerance {
+        Logger.Warn(fmt.Sprintf("Height differs between %s (%.1fcm) and %s (%.1fcm), BMI on %s will differ", s.Source.Name(), src_height, sink.Name(), sink_height, sink.Name()))
+    }
+}
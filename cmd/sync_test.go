@@ -0,0 +1,363 @@
+package main
+
+import (
+    "errors"
+    "math"
+    "path/filepath"
+    "testing"
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+// historyがtrueの場合はCSVと同じく初回の同期で全ての期間を取得する
+// locを指定しない場合は実行しているホストのタイムゾーンで日付を区切る
+type fakeSource struct {
+    data []measurement.BodyMeasurement
+    history bool
+    loc *time.Location
+}
+
+func (s *fakeSource) Name() string {
+    return "fake"
+}
+
+func (s *fakeSource) Location() *time.Location {
+    if s.loc == nil {
+        return time.Local
+    }
+    return s.loc
+}
+
+func (s *fakeSource) FullHistory() bool {
+    return s.history
+}
+
+func (s *fakeSource) Measurements(from time.Time, to time.Time) ([]measurement.BodyMeasurement, error) {
+    var ret []measurement.BodyMeasurement
+    for _, m := range s.data {
+        if !m.Date.Before(from) && !m.Date.After(to) {
+            ret = append(ret, m)
+        }
+    }
+    return ret, nil
+}
+
+// Fitbitと同じく期間の記録をまとめて取得でき、書き込みはmax_writes回で失敗する送信先
+type fakeSink struct {
+    loc *time.Location
+    records []measurement.BodyMeasurement
+    max_writes int
+    day_requests int
+    range_requests int
+}
+
+func (s *fakeSink) Name() string {
+    return "fake_sink"
+}
+
+func (s *fakeSink) Location() *time.Location {
+    return s.loc
+}
+
+func (s *fakeSink) Existing(date time.Time) ([]measurement.BodyMeasurement, error) {
+    s.day_requests++
+    return s.ExistingRange(date, date)
+}
+
+func (s *fakeSink) ExistingRange(from time.Time, to time.Time) ([]measurement.BodyMeasurement, error) {
+    s.range_requests++
+    var ret []measurement.BodyMeasurement
+    for _, r := range s.records {
+        d := day_key(r.Date.In(s.loc))
+        if d >= day_key(from.In(s.loc)) && d <= day_key(to.In(s.loc)) {
+            ret = append(ret, r)
+        }
+    }
+    return ret, nil
+}
+
+func (s *fakeSink) Write(m measurement.BodyMeasurement) error {
+    if s.max_writes >= 0 && len(s.records) >= s.max_writes {
+        return errors.New("(429) Too Many Requests")
+    }
+    s.records = append(s.records, m)
+    return nil
+}
+
+func new_test_syncr(t *testing.T, src measurement.Source, sink measurement.Sink, conf *syncConfig) *Syncr {
+    t.Helper()
+    dir := t.TempDir()
+    st, err := load_state(filepath.Join(dir, "state.json"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    q, err := load_quarantine(filepath.Join(dir, "quarantine.json"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return NewSyncr(src, []measurement.Sink{sink}, st, q, conf)
+}
+
+// 毎日7:00の測定をdays日分
+func daily_measurements(start time.Time, days int) []measurement.BodyMeasurement {
+    var ret []measurement.BodyMeasurement
+    for i := 0; i < days; i++ {
+        ret = append(ret, measurement.BodyMeasurement{Date: start.AddDate(0, 0, i), Weight: 60 + float64(i % 10) / 10})
+    }
+    return ret
+}
+
+func TestSyncImportHistory(t *testing.T) {
+    loc := time.FixedZone("JST", 9 * 60 * 60)
+    src := &fakeSource{data: daily_measurements(time.Date(2023, 1, 1, 7, 0, 0, 0, loc), 400), history: true}
+
+    // 途中で書き込めなくなっても、書き込んだ単位までは進捗に残る
+    sink := &fakeSink{loc: loc, max_writes: 100}
+    s := new_test_syncr(t, src, sink, &syncConfig{})
+    ret, err := s.Sync(false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    r := ret.Sinks[0]
+    if r.Err == nil || r.Written != 100 {
+        t.Fatalf("result = %s", r)
+    }
+    if sink.day_requests != 0 {
+        t.Errorf("requested %d days one by one", sink.day_requests)
+    }
+    // 31日ずつ比較するので、書き込めた3単位(93日)分まで進む
+    synced := s.State.SyncedUntil(s.state_key(sink))
+    if want := src.data[92].Date; !synced.Equal(want) {
+        t.Errorf("synced until %s, want %s", synced, want)
+    }
+
+    // 再実行すると続きから書き込み、重複しない
+    sink.max_writes = -1
+    sink.range_requests = 0
+    ret, err = s.Sync(false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    r = ret.Sinks[0]
+    if r.Err != nil || r.Written != 300 || len(sink.records) != 400 {
+        t.Fatalf("result = %s, records = %d", r, len(sink.records))
+    }
+    if max := (400 + sync_batch_days - 1) / sync_batch_days; sink.range_requests > max {
+        t.Errorf("range requests = %d, want <= %d", sink.range_requests, max)
+    }
+    if !s.State.SyncedUntil(s.state_key(sink)).After(src.data[399].Date) {
+        t.Errorf("synced until %s", s.State.SyncedUntil(s.state_key(sink)))
+    }
+}
+
+func TestSplitBatches(t *testing.T) {
+    loc := time.FixedZone("JST", 9 * 60 * 60)
+    sink := &fakeSink{loc: loc}
+    // 同じ日の測定は同じ単位にする
+    data := daily_measurements(time.Date(2024, 1, 1, 7, 0, 0, 0, loc), 62)
+    data = append(data, measurement.BodyMeasurement{Date: time.Date(2024, 3, 2, 23, 0, 0, 0, loc), Weight: 60})
+    batches := split_batches(sink, data)
+    if len(batches) != 2 || len(batches[0]) != 31 || len(batches[1]) != 32 {
+        t.Fatalf("batches = %d", len(batches))
+    }
+    if got := batches[1][0].Date; !got.Equal(time.Date(2024, 2, 1, 7, 0, 0, 0, loc)) {
+        t.Errorf("second batch starts at %s", got)
+    }
+    if len(split_batches(sink, nil)) != 0 {
+        t.Errorf("empty targets")
+    }
+}
+
+// HealthPlanetと同じく登録日時で絞り込む取得元
+type registeredSource struct {
+    data []measurement.BodyMeasurement
+    registered []time.Time
+    requests []time.Time
+    loc *time.Location
+}
+
+func (s *registeredSource) Name() string {
+    return "registered"
+}
+
+func (s *registeredSource) Location() *time.Location {
+    if s.loc == nil {
+        return time.Local
+    }
+    return s.loc
+}
+
+func (s *registeredSource) Measurements(from time.Time, to time.Time) ([]measurement.BodyMeasurement, error) {
+    s.requests = append(s.requests, from)
+    var ret []measurement.BodyMeasurement
+    for i, r := range s.registered {
+        if !r.Before(from) && !r.After(to) {
+            ret = append(ret, s.data[i])
+        }
+    }
+    return ret, nil
+}
+
+// 前回の同期より前に送信済みの日の測定が、後から登録された場合
+func TestSyncDailyPartialDay(t *testing.T) {
+    now := time.Now()
+    yesterday := start_of_day(now).AddDate(0, 0, -1)
+    day := yesterday.AddDate(0, 0, -1)
+
+    first := measurement.BodyMeasurement{Date: day.Add(7 * time.Hour), Weight: 60}
+    late := measurement.BodyMeasurement{Date: day.Add(22 * time.Hour + 30 * time.Minute), Weight: 60.4}
+    src := &registeredSource{
+        data: []measurement.BodyMeasurement{first, late},
+        // 22:30の測定は次の日の夜に登録された
+        registered: []time.Time{first.Date.Add(5 * time.Minute), yesterday.Add(22 * time.Hour + 30 * time.Minute)},
+    }
+
+    for _, policy := range []string{daily_first, daily_morning, daily_average} {
+        t.Run(policy, func(t *testing.T) {
+            src.requests = nil
+            sink := &fakeSink{loc: time.Local, max_writes: -1, records: []measurement.BodyMeasurement{first}}
+            s := new_test_syncr(t, src, sink, &syncConfig{Daily: policy})
+            s.State.SetSyncedUntil(s.state_key(sink), yesterday.Add(23 * time.Hour))
+
+            ret, err := s.Sync(false)
+            if err != nil {
+                t.Fatal(err)
+            }
+            // その日の0時から取得し直して、その日の全ての測定から選ぶ
+            if len(src.requests) != 2 || !src.requests[1].Equal(day) {
+                t.Errorf("requests = %v", src.requests)
+            }
+            // 最初の測定(平均の場合も最初の測定の日時)は送信済み
+            if r := ret.Sinks[0]; r.Err != nil || r.Written != 0 {
+                t.Errorf("result = %s, records = %v", r, sink.records)
+            }
+        })
+    }
+}
+
+func TestSyncApprovedStatus(t *testing.T) {
+    yesterday := start_of_day(time.Now()).AddDate(0, 0, -1)
+    guest := measurement.BodyMeasurement{Date: yesterday.Add(6 * time.Hour), Weight: 80}
+    own := measurement.BodyMeasurement{Date: yesterday.Add(7 * time.Hour), Weight: 60}
+
+    tests := []struct {
+        name string
+        daily string
+        approved measurement.BodyMeasurement
+        max_writes int
+        unprepared []string
+        want string
+    }{
+        {"written", daily_all, guest, -1, nil, quarantine_synced},
+        // その日の最初の測定として送信した
+        {"selected", daily_first, guest, -1, nil, quarantine_synced},
+        // その日の最後の測定ではないので送信しない
+        {"not selected", daily_last, guest, -1, nil, quarantine_skipped},
+        {"write failed", daily_all, guest, 0, nil, quarantine_approved},
+        {"sink not prepared", daily_all, guest, -1, []string{"webhook"}, quarantine_approved},
+        // 送信先に記録済み
+        {"already exists", daily_last, own, 0, nil, quarantine_synced},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            src := &fakeSource{data: []measurement.BodyMeasurement{guest, own}}
+            sink := &fakeSink{loc: time.Local, max_writes: tt.max_writes}
+            if tt.approved.Date.Equal(own.Date) {
+                sink.records = []measurement.BodyMeasurement{own}
+            }
+            s := new_test_syncr(t, src, sink, &syncConfig{Daily: tt.daily, Filter: &filterConfig{MaxWeight: 70}})
+            s.Unprepared = tt.unprepared
+            // 体重の確認で弾かれて承認された測定
+            s.Quarantine.Add(tt.approved, "test")
+            _, err := s.Quarantine.Approve(quarantine_id(tt.approved))
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            _, err = s.Sync(false)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got := s.Quarantine.Find(quarantine_id(tt.approved)).Status; got != tt.want {
+                t.Errorf("status = %s, want %s", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestSyncRangeHistory(t *testing.T) {
+    now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+    tests := []struct {
+        name string
+        history bool
+        synced time.Time
+        want time.Time
+    }{
+        {"first sync", false, time.Time{}, now.Add(-default_sync_window)},
+        // 過去のデータを取り込む取得元は初回だけ全ての期間
+        {"first sync of history", true, time.Time{}, time.Time{}},
+        {"next sync of history", true, now.Add(-time.Hour), now.Add(-time.Hour - sync_overlap)},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            sink := &fakeSink{loc: time.UTC}
+            s := new_test_syncr(t, &fakeSource{history: tt.history}, sink, &syncConfig{})
+            if !tt.synced.IsZero() {
+                s.State.SetSyncedUntil(s.state_key(sink), tt.synced)
+            }
+            if got := s.sync_range(sink, now); !got.Equal(tt.want) {
+                t.Errorf("sync_range = %s, want %s", got, tt.want)
+            }
+        })
+    }
+}
+
+// 実行しているホスト(UTC)と取得元(JST)のタイムゾーンが違う場合
+// 取得元の日付の0時から取得しないと、UTCの0時(JSTの9時)より前の測定を取りこぼす
+func TestSyncDailySourceTimezone(t *testing.T) {
+    local := time.Local
+    time.Local = time.UTC
+    t.Cleanup(func() { time.Local = local })
+
+    jst := time.FixedZone("JST", 9 * 60 * 60)
+    yesterday := start_of_day(time.Now().In(jst)).AddDate(0, 0, -1)
+    first := measurement.BodyMeasurement{Date: yesterday.Add(7 * time.Hour), Weight: 60}
+    second := measurement.BodyMeasurement{Date: yesterday.Add(8 * time.Hour), Weight: 60.4}
+
+    tests := []struct {
+        policy string
+        want measurement.BodyMeasurement
+    }{
+        {daily_last, second},
+        // 平均は最初の測定の日時で送信する
+        {daily_average, measurement.BodyMeasurement{Date: first.Date, Weight: 60.2}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.policy, func(t *testing.T) {
+            src := &registeredSource{
+                data: []measurement.BodyMeasurement{first, second},
+                registered: []time.Time{first.Date.Add(5 * time.Minute), second.Date.Add(5 * time.Minute)},
+                loc: jst,
+            }
+            sink := &fakeSink{loc: jst, max_writes: -1}
+            s := new_test_syncr(t, src, sink, &syncConfig{Daily: tt.policy})
+            // 前回の同期(JSTの12時)ではその日が終わっていないので送信していない
+            s.State.SetSyncedUntil(s.state_key(sink), yesterday.Add(12 * time.Hour))
+
+            ret, err := s.Sync(false)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(src.requests) == 0 || !src.requests[0].Equal(yesterday) {
+                t.Errorf("requests = %v, want from %s", src.requests, yesterday)
+            }
+            if r := ret.Sinks[0]; r.Err != nil || r.Written != 1 {
+                t.Fatalf("result = %s, records = %v", r, sink.records)
+            }
+            if got := sink.records[0]; !got.Date.Equal(tt.want.Date) || math.Abs(got.Weight - tt.want.Weight) > 1e-9 {
+                t.Errorf("record = %s, want %s", &got, &tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+    "fmt"
+    "errors"
+    "strings"
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/withings"
+)
+
+const (
+    provider_healthplanet = "healthplanet"
+    provider_fitbit = "fitbit"
+    provider_withings = "withings"
+)
+
+var support_providers = []string{provider_healthplanet, provider_fitbit, provider_withings}
+
+// providerが空の場合はHealthPlanetとFitbit、送信先にWithingsがあればWithingsも対象にする
+func select_providers(p profile, provider string) ([]string, error) {
+    if provider == "" {
+        providers := []string{provider_healthplanet, provider_fitbit}
+        if p.HasSink(sink_withings) {
+            providers = append(providers, provider_withings)
+        }
+        return providers, nil
+    }
+    if !contains(support_providers, provider) {
+        return nil, errors.New(fmt.Sprintf("Unknown provider: %s. Support providers are %s", provider, support_providers))
+    }
+    return []string{provider}, nil
+}
+
+func format_unix(t int64) string {
+    if t == 0 {
+        return "unknown"
+    }
+    return time.Unix(t, 0).Format(time.RFC3339)
+}
+
+func print_healthplanet_token_status(p profile) {
+    fmt.Println("  HealthPlanet")
+    fmt.Printf("    token_file: %s\n", p.HealthPlanet.TokenFile)
+
+    hp_auth := get_healthplanet_auth(p)
+    err := hp_auth.LoadToken()
+    if err != nil {
+        fmt.Printf("    error: %s\n", err)
+        return
+    }
+
+    token := hp_auth.Token()
+    fmt.Printf("    issued: %s\n", format_unix(token.Create_date))
+    fmt.Printf("    expires: %s\n", format_until(token.ExpiresAt()))
+    fmt.Printf("    refresh after: %s\n", format_until(token.RefreshAt()))
+}
+
+func print_fitbit_token_status(p profile) {
+    fmt.Println("  Fitbit")
+    fmt.Printf("    token_file: %s\n", p.Fitbit.TokenFile)
+
+    fb_auth := get_fitbit_auth(p)
+    err := fb_auth.LoadToken()
+    if err != nil {
+        fmt.Printf("    error: %s\n", err)
+        return
+    }
+
+    token := fb_auth.Token()
+    fmt.Printf("    user_id: %s\n", token.User_id)
+    fmt.Printf("    scope: %s\n", token.Scope)
+    fmt.Printf("    issued: %s\n", format_unix(token.Create_date))
+    // Fitbitは同期の度にリフレッシュするので、アクセストークンの期限がそのままリフレッシュの目安になる
+    if expires_at, ok := token.ExpiresAt(); ok {
+        fmt.Printf("    expires: %s\n", format_until(expires_at))
+    } else {
+        fmt.Printf("    expires: unknown\n")
+    }
+    fmt.Printf("    refresh after: every sync\n")
+}
+
+func print_withings_token_status(p profile) {
+    fmt.Println("  Withings")
+    fmt.Printf("    token_file: %s\n", p.Withings.TokenFile)
+
+    wi_auth := get_withings_auth(p)
+    err := wi_auth.LoadToken()
+    if err != nil {
+        fmt.Printf("    error: %s\n", err)
+        return
+    }
+
+    token := wi_auth.Token()
+    fmt.Printf("    user_id: %s\n", token.User_id)
+    fmt.Printf("    scope: %s\n", token.Scope)
+    fmt.Printf("    issued: %s\n", format_unix(token.Create_date))
+    if expires_at, ok := token.ExpiresAt(); ok {
+        fmt.Printf("    expires: %s\n", format_until(expires_at))
+        fmt.Printf("    refresh after: %s\n", format_until(expires_at.Add(-withings.TokenRefreshThreshold * time.Second)))
+    } else {
+        fmt.Printf("    expires: unknown\n")
+    }
+}
+
+func run_token_status(conf config, profile_name string, provider string) error {
+    profiles, err := conf.SelectProfiles(profile_name)
+    if err != nil {
+        return err
+    }
+
+    for _, p := range profiles {
+        providers, err := select_providers(p, provider)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("[%s]\n", p.Name)
+        if contains(providers, provider_healthplanet) {
+            print_healthplanet_token_status(p)
+        }
+        if contains(providers, provider_fitbit) {
+            print_fitbit_token_status(p)
+        }
+        if contains(providers, provider_withings) {
+            print_withings_token_status(p)
+        }
+    }
+    return nil
+}
+
+func refresh_healthplanet_token(p profile) error {
+    hp_auth := get_healthplanet_auth(p)
+    err := hp_auth.LoadToken()
+    if err != nil {
+        return err
+    }
+    return hp_auth.ForceRefreshToken()
+}
+
+func refresh_fitbit_token(p profile) error {
+    fb_auth := get_fitbit_auth(p)
+    err := fb_auth.LoadToken()
+    if err != nil {
+        return err
+    }
+    return fb_auth.RefreshToken()
+}
+
+func refresh_withings_token(p profile) error {
+    wi_auth := get_withings_auth(p)
+    err := wi_auth.LoadToken()
+    if err != nil {
+        return err
+    }
+    return wi_auth.ForceRefreshToken()
+}
+
+// IsTokenNeedRefreshに関わらずトークンをリフレッシュする
+func run_refresh_token(conf config, profile_name string, provider string) error {
+    profiles, err := conf.SelectProfiles(profile_name)
+    if err != nil {
+        return err
+    }
+
+    var failed []string
+    for _, p := range profiles {
+        providers, err := select_providers(p, provider)
+        if err != nil {
+            return err
+        }
+        for _, pr := range providers {
+            switch pr {
+            case provider_healthplanet:
+                err = refresh_healthplanet_token(p)
+            case provider_fitbit:
+                err = refresh_fitbit_token(p)
+            case provider_withings:
+                err = refresh_withings_token(p)
+            }
+            if err != nil {
+                Logger.Error(fmt.Sprintf("Refresh %s token of profile %s failed: %s", pr, p.Name, err))
+                failed = append(failed, fmt.Sprintf("%s/%s", p.Name, pr))
+                continue
+            }
+            fmt.Printf("[%s] %s: refreshed\n", p.Name, pr)
+        }
+    }
+
+    if len(failed) > 0 {
+        return errors.New(fmt.Sprintf("Failed tokens: %s", strings.Join(failed, ", ")))
+    }
+    return nil
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+    "fmt"
+    "errors"
+    "net/url"
+    "strings"
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/csv_source"
+    "github.com/kamaboko123/tanita_to_fitbit/influxdb"
+    "github.com/kamaboko123/tanita_to_fitbit/mqtt"
+    "github.com/kamaboko123/tanita_to_fitbit/webhook"
+)
+
+const placeholder_prefix = "PUT_YOUR_"
+
+type configErrors []error
+
+func (e configErrors) Error() string {
+    var lines []string
+    for _, err := range e {
+        lines = append(lines, "  - " + err.Error())
+    }
+    return fmt.Sprintf("%d problem(s) in config:\n%s", len(e), strings.Join(lines, "\n"))
+}
+
+type configValidator struct {
+    errs configErrors
+}
+
+func (v *configValidator) add(field string, format string, a ...any) {
+    v.errs = append(v.errs, errors.New(fmt.Sprintf("%s: %s", field, fmt.Sprintf(format, a...))))
+}
+
+func (v *configValidator) required(field string, value string) {
+    if value == "" {
+        v.add(field, "required")
+        return
+    }
+    if strings.HasPrefix(value, placeholder_prefix) {
+        v.add(field, "placeholder value %q is not replaced", value)
+    }
+}
+
+func (v *configValidator) timezone(field string, value string) {
+    if value == "" {
+        v.add(field, "required (e.g. \"Asia/Tokyo\")")
+        return
+    }
+    if _, err := time.LoadLocation(value); err != nil {
+        v.add(field, "invalid time zone %q", value)
+    }
+}
+
+func (v *configValidator) url(field string, value string) {
+    u, err := url.Parse(value)
+    if err != nil {
+        v.add(field, "invalid URL %q: %s", value, err)
+        return
+    }
+    if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        v.add(field, "invalid URL %q: must be http(s)://host", value)
+    }
+}
+
+// 省略可能な"15:04"形式の時刻
+func (v *configValidator) clock(field string, value string) bool {
+    if value == "" {
+        return true
+    }
+    if _, err := parse_clock(value); err != nil {
+        v.add(field, "invalid time %q, must be HH:MM", value)
+        return false
+    }
+    return true
+}
+
+func (v *configValidator) filter(field string, f *filterConfig) {
+    if f == nil {
+        return
+    }
+    if f.MinWeight < 0 || f.MaxWeight < 0 || f.MaxDeviation < 0 || f.MinBodyFat < 0 || f.MaxBodyFat < 0 {
+        v.add(field, "values must not be negative")
+    }
+    if f.MinWeight > 0 && f.MaxWeight > 0 && f.MinWeight >= f.MaxWeight {
+        v.add(field + ".max_weight", "must be larger than min_weight")
+    }
+    if f.MinBodyFat > 0 && f.MaxBodyFat > 0 && f.MinBodyFat >= f.MaxBodyFat {
+        v.add(field + ".max_body_fat", "must be larger than min_body_fat")
+    }
+}
+
+func (v *configValidator) csv(field string, c *csv_source.Config) {
+    if c == nil {
+        v.add(field, "required when source is \"csv\"")
+        return
+    }
+    v.required(field + ".path", c.Path)
+    v.required(field + ".date_column", c.DateColumn)
+    v.required(field + ".date_format", c.DateFormat)
+    v.timezone(field + ".timezone", c.Timezone)
+    v.required(field + ".weight_column", c.WeightColumn)
+    if c.WeightUnit != "" && c.WeightUnit != "kg" && c.WeightUnit != "lb" {
+        v.add(field + ".weight_unit", "must be \"kg\" or \"lb\"")
+    }
+    if len([]rune(c.Delimiter)) > 1 {
+        v.add(field + ".delimiter", "must be a single character")
+    }
+}
+
+func (v *configValidator) influxdb(field string, c *influxdb.Config) {
+    if c == nil {
+        v.add(field, "required when sinks include \"influxdb\"")
+        return
+    }
+    if c.File != "" {
+        return
+    }
+    v.url(field + ".url", c.Url)
+    v.required(field + ".database", c.Database)
+    if c.Org != "" {
+        v.required(field + ".token", c.Token)
+    }
+}
+
+func (v *configValidator) webhook(field string, c *webhook.Config) {
+    if c == nil {
+        v.add(field, "required when sinks include \"webhook\"")
+        return
+    }
+    v.url(field + ".url", c.Url)
+    if c.Secret != "" {
+        v.required(field + ".secret", c.Secret)
+    }
+    if c.MaxRetries != nil && *c.MaxRetries < 0 {
+        v.add(field + ".max_retries", "must not be negative")
+    }
+    if c.RetryInterval != nil && *c.RetryInterval < 0 {
+        v.add(field + ".retry_interval", "must not be negative")
+    }
+}
+
+func (v *configValidator) mqtt(field string, c *mqtt.Config) {
+    if c == nil {
+        v.add(field, "required when sinks include \"mqtt\"")
+        return
+    }
+    u, err := url.Parse(c.Broker)
+    if err != nil || u.Host == "" || !contains([]string{"tcp", "mqtt", "mqtts", "ssl", "tls"}, u.Scheme) {
+        v.add(field + ".broker", "invalid broker %q: must be tcp://host:port or mqtts://host:port", c.Broker)
+    }
+    if c.Qos > 2 {
+        v.add(field + ".qos", "must be 0, 1 or 2")
+    }
+}
+
+func field_prefix(p profile, single bool) string {
+    if single && p.Name == default_profile_name {
+        return ""
+    }
+    return fmt.Sprintf("profiles[%s].", p.Name)
+}
+
+// 設定のうち、実行するモードで確認するセクション
+type validateScope struct {
+    // 取得元・送信先・同期の設定 (sync, dry-sync, doctor, check-config)
+    sync bool
+    // 認証情報を確認するprovider
+    providers []string
+    // trueの場合はproviderをselect_providersでプロファイルごとに選ぶ (refresh-token, logout)
+    token_providers bool
+    provider string
+}
+
+// 実行しないモードの設定の問題で止めないように、モードごとに必要なセクションだけ確認する
+// 例えばinit_healthplanetはFitbitの設定が無くても実行でき、quarantine/approve/rejectは認証情報を使わない
+func mode_scope(mode string, provider string) validateScope {
+    switch mode {
+    case "init_healthplanet", "bp-export", "export":
+        return validateScope{providers: []string{provider_healthplanet}}
+    case "init_fitbit", "fitbit-export":
+        return validateScope{providers: []string{provider_fitbit}}
+    case "init_withings":
+        return validateScope{providers: []string{provider_withings}}
+    case "refresh-token", "logout":
+        return validateScope{token_providers: true, provider: provider}
+    case "token-status", "quarantine", "approve", "reject":
+        return validateScope{}
+    default:
+        return validateScope{sync: true}
+    }
+}
+
+func (v *configValidator) provider(prefix string, p profile, provider string) {
+    switch provider {
+    case provider_healthplanet:
+        v.required(prefix + "health_planet.client_id", p.HealthPlanet.ClientId)
+        v.required(prefix + "health_planet.client_secret", p.HealthPlanet.ClientSecret)
+        v.timezone(prefix + "health_planet.timezone", p.HealthPlanet.Timezone)
+        v.url(prefix + "health_planet.url", p.HealthPlanet.Url)
+    case provider_fitbit:
+        v.required(prefix + "fitbit.client_id", p.Fitbit.ClientId)
+        v.required(prefix + "fitbit.client_secret", p.Fitbit.ClientSecret)
+        v.timezone(prefix + "fitbit.timezone", p.Fitbit.Timezone)
+        v.url(prefix + "fitbit.url", p.Fitbit.Url)
+    case provider_withings:
+        v.required(prefix + "withings.client_id", p.Withings.ClientId)
+        v.required(prefix + "withings.client_secret", p.Withings.ClientSecret)
+        v.timezone(prefix + "withings.timezone", p.Withings.Timezone)
+        v.url(prefix + "withings.url", p.Withings.Url)
+        v.url(prefix + "withings.auth_url", p.Withings.AuthUrl)
+    }
+}
+
+func (v *configValidator) sync(prefix string, p profile) {
+    switch p.Source {
+    case source_health_planet:
+        v.provider(prefix, p, provider_healthplanet)
+    case source_csv:
+        v.csv(prefix + "csv", p.CSV)
+    case source_fitbit:
+        // Fitbitの設定は下で確認する
+        if p.HasSink(sink_fitbit) {
+            v.add(prefix + "sinks", "\"fitbit\" cannot be a sink when source is \"fitbit\"")
+        }
+    default:
+        v.add(prefix + "source", "unknown source %q, must be one of %s", p.Source, support_sources)
+    }
+
+    if !contains(support_duplicate_policies, p.Sync.duplicate_policy()) {
+        v.add(prefix + "sync.fitbit_duplicate_policy", "unknown policy %q, must be one of %s", p.Sync.FitbitDuplicatePolicy, support_duplicate_policies)
+    }
+    if !contains(support_daily_policies, p.Sync.daily_policy()) {
+        v.add(prefix + "sync.daily", "unknown policy %q, must be one of %s", p.Sync.Daily, support_daily_policies)
+    }
+    if p.Sync.daily_policy() == daily_morning {
+        start_ok := v.clock(prefix + "sync.morning_start", p.Sync.MorningStart)
+        end_ok := v.clock(prefix + "sync.morning_end", p.Sync.MorningEnd)
+        if start, end := p.Sync.morning_window(); start_ok && end_ok && start >= end {
+            v.add(prefix + "sync.morning_end", "must be after morning_start")
+        }
+    }
+    v.filter(prefix + "sync.filter", p.Sync.Filter)
+    for _, sink := range p.Sinks {
+        if !contains(support_sinks, sink) {
+            v.add(prefix + "sinks", "unknown sink %q, must be one of %s", sink, support_sinks)
+        }
+    }
+    if p.Sync.Pedometer && p.Source != source_health_planet {
+        v.add(prefix + "sync.pedometer", "needs source \"health_planet\"")
+    }
+    // 取得元がFitbitの場合と、歩数計の同期(Fitbitに記録する)の場合はsinksに関わらずFitbitの設定が必要
+    if p.Source == source_fitbit || p.HasSink(sink_fitbit) || p.Sync.Pedometer {
+        v.provider(prefix, p, provider_fitbit)
+    }
+    if p.HasSink(sink_withings) {
+        v.provider(prefix, p, provider_withings)
+    }
+    if p.HasSink(sink_influxdb) {
+        v.influxdb(prefix + "influxdb", p.InfluxDB)
+    }
+    if p.HasSink(sink_webhook) {
+        v.webhook(prefix + "webhook", p.Webhook)
+    }
+    if p.HasSink(sink_mqtt) {
+        v.mqtt(prefix + "mqtt", p.MQTT)
+    }
+}
+
+// 設定の問題を最初の1件で止めずに全て集めて返す
+// 問題が無い場合はnilを返す
+func (c *config) Validate(profile_name string, scope validateScope) error {
+    profiles, err := c.SelectProfiles(profile_name)
+    if err != nil {
+        return configErrors{err}
+    }
+
+    v := &configValidator{}
+    for _, p := range profiles {
+        prefix := field_prefix(p, len(c.Profiles) == 0)
+
+        if scope.sync {
+            v.sync(prefix, p)
+        }
+        providers := scope.providers
+        if scope.token_providers {
+            providers, err = select_providers(p, scope.provider)
+            if err != nil {
+                return configErrors{err}
+            }
+        }
+        for _, pr := range providers {
+            v.provider(prefix, p, pr)
+        }
+    }
+
+    if len(v.errs) > 0 {
+        return v.errs
+    }
+    return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "github.com/kamaboko123/tanita_to_fitbit/webhook"
+)
+
+func validate_fields(t *testing.T, c *config, mode string, provider string) []string {
+    t.Helper()
+    err := c.Validate("", mode_scope(mode, provider))
+    if err == nil {
+        return nil
+    }
+    errs, ok := err.(configErrors)
+    if !ok {
+        t.Fatalf("unexpected error type %T: %s", err, err)
+    }
+    var fields []string
+    for _, e := range errs {
+        fields = append(fields, strings.SplitN(e.Error(), ":", 2)[0])
+    }
+    return fields
+}
+
+func TestValidateModeScope(t *testing.T) {
+    // HealthPlanetの設定だけがあり、Fitbitの設定が無い
+    c := &config{
+        HealthPlanet: healthPlanetConfig{ClientId: "hp_id", ClientSecret: "hp_secret", Timezone: "Asia/Tokyo"},
+    }
+    tests := []struct {
+        mode string
+        provider string
+        want []string
+    }{
+        {"init_healthplanet", "", nil},
+        {"export", "", nil},
+        {"bp-export", "", nil},
+        {"quarantine", "", nil},
+        {"approve", "", nil},
+        {"reject", "", nil},
+        {"token-status", "", nil},
+        {"refresh-token", "healthplanet", nil},
+        {"init_fitbit", "", []string{"fitbit.client_id", "fitbit.client_secret", "fitbit.timezone"}},
+        {"fitbit-export", "", []string{"fitbit.client_id", "fitbit.client_secret", "fitbit.timezone"}},
+        {"init_withings", "", []string{"withings.client_id", "withings.client_secret", "withings.timezone"}},
+        {"logout", "fitbit", []string{"fitbit.client_id", "fitbit.client_secret", "fitbit.timezone"}},
+        // 既定の送信先はFitbit
+        {"sync", "", []string{"fitbit.client_id", "fitbit.client_secret", "fitbit.timezone"}},
+        {"dry-sync", "", []string{"fitbit.client_id", "fitbit.client_secret", "fitbit.timezone"}},
+        {"check-config", "", []string{"fitbit.client_id", "fitbit.client_secret", "fitbit.timezone"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.mode + "/" + tt.provider, func(t *testing.T) {
+            got := validate_fields(t, c, tt.mode, tt.provider)
+            if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+                t.Errorf("errors = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestValidateSync(t *testing.T) {
+    hp := healthPlanetConfig{ClientId: "hp_id", ClientSecret: "hp_secret", Timezone: "Asia/Tokyo"}
+    fb := fitbitConfig{ClientId: "fb_id", ClientSecret: "fb_secret", Timezone: "Asia/Tokyo"}
+    zero, negative := 0, -1
+    zero_interval, negative_interval := 0.0, -0.5
+    tests := []struct {
+        name string
+        conf config
+        want []string
+    }{
+        {"ok", config{HealthPlanet: hp, Fitbit: fb}, nil},
+        {
+            "placeholder",
+            config{HealthPlanet: hp, Fitbit: fitbitConfig{ClientId: "PUT_YOUR_CLIENT_ID", ClientSecret: "fb_secret", Timezone: "Asia/Tokyo"}},
+            []string{"fitbit.client_id"},
+        },
+        {
+            "invalid timezone and url",
+            config{HealthPlanet: healthPlanetConfig{ClientId: "hp_id", ClientSecret: "hp_secret", Timezone: "Mars/Base", Url: "ftp://example.com"}, Fitbit: fb},
+            []string{"health_planet.timezone", "health_planet.url"},
+        },
+        // 送信先にFitbitが無ければFitbitの設定は不要
+        {"no fitbit sink", config{HealthPlanet: hp, Sinks: []string{sink_influxdb}, InfluxDB: nil}, []string{"influxdb"}},
+        {"unknown source and sink", config{Fitbit: fb, Source: "tanita", Sinks: []string{sink_fitbit, "garmin"}}, []string{"source", "sinks"}},
+        {"fitbit to fitbit", config{Fitbit: fb, Source: source_fitbit}, []string{"sinks"}},
+        {"pedometer without health_planet", config{Fitbit: fb, Source: source_fitbit, Sinks: []string{sink_influxdb}, InfluxDB: nil, Sync: &syncConfig{Pedometer: true}}, []string{"sync.pedometer", "influxdb"}},
+        {
+            "morning window",
+            config{HealthPlanet: hp, Fitbit: fb, Sync: &syncConfig{Daily: daily_morning, MorningStart: "10:00", MorningEnd: "09:00"}},
+            []string{"sync.morning_end"},
+        },
+        {
+            "filter",
+            config{HealthPlanet: hp, Fitbit: fb, Sync: &syncConfig{Filter: &filterConfig{MinWeight: 80, MaxWeight: 40}}},
+            []string{"sync.filter.max_weight"},
+        },
+        {"csv without section", config{Fitbit: fb, Source: source_csv}, []string{"csv"}},
+        // 0は再試行しない設定として使えるが、負の値は使えない
+        {"webhook no retries", config{HealthPlanet: hp, Sinks: []string{sink_webhook}, Webhook: &webhook.Config{Url: "http://hook", MaxRetries: &zero, RetryInterval: &zero_interval}}, nil},
+        {"webhook negative retries", config{HealthPlanet: hp, Sinks: []string{sink_webhook}, Webhook: &webhook.Config{Url: "http://hook", MaxRetries: &negative, RetryInterval: &negative_interval}}, []string{"webhook.max_retries", "webhook.retry_interval"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := validate_fields(t, &tt.conf, "sync", "")
+            if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+                t.Errorf("errors = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestValidateProfilePrefix(t *testing.T) {
+    c := &config{
+        HealthPlanet: healthPlanetConfig{ClientId: "hp_id", ClientSecret: "hp_secret", Timezone: "Asia/Tokyo"},
+        Profiles: []profile{
+            {Name: "alice", Fitbit: fitbitConfig{ClientId: "fb_id", ClientSecret: "fb_secret", Timezone: "Asia/Tokyo"}},
+            {Name: "bob"},
+        },
+    }
+    got := validate_fields(t, c, "sync", "")
+    want := []string{"profiles[bob].fitbit.client_id", "profiles[bob].fitbit.client_secret", "profiles[bob].fitbit.timezone"}
+    if strings.Join(got, ",") != strings.Join(want, ",") {
+        t.Errorf("errors = %v, want %v", got, want)
+    }
+
+    // 存在しないプロファイルは設定の問題として返す
+    if err := c.Validate("carol", mode_scope("quarantine", "")); err == nil {
+        t.Errorf("expected error for unknown profile")
+    }
+}
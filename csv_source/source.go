@@ -0,0 +1,226 @@
+package csv_source
+
+import (
+    "encoding/csv"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+    "log/slog"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+const pound_to_kg = 0.45359237
+
+// CSVファイルの読み方
+// 列はヘッダの名前で指定する(no_headerの場合は1から始まる列番号)
+type Config struct {
+    Path string `json:"path"`
+    Delimiter string `json:"delimiter"`
+    NoHeader bool `json:"no_header"`
+
+    DateColumn string `json:"date_column"`
+    // 日付と時刻が別の列の場合に指定する (date_formatは"日付 時刻"の形式で書く)
+    TimeColumn string `json:"time_column"`
+    // Goの日時フォーマット (例: "2006/01/02 15:04")、"unix"の場合はUNIX時間
+    DateFormat string `json:"date_format"`
+    Timezone string `json:"timezone"`
+
+    WeightColumn string `json:"weight_column"`
+    // kg または lb (省略時はkg)
+    WeightUnit string `json:"weight_unit"`
+    BodyFatColumn string `json:"body_fat_column"`
+    ModelColumn string `json:"model_column"`
+}
+
+type Source struct {
+    conf Config
+    timezone *time.Location
+    Logger *slog.Logger
+}
+
+func NewSource(conf Config, logger *slog.Logger) (*Source, error) {
+    tz, err := time.LoadLocation(conf.Timezone)
+    if err != nil {
+        return nil, err
+    }
+    if conf.WeightUnit != "" && conf.WeightUnit != "kg" && conf.WeightUnit != "lb" {
+        return nil, errors.New(fmt.Sprintf("[csv]Unknown weight unit: %s", conf.WeightUnit))
+    }
+    return &Source{conf: conf, timezone: tz, Logger: logger}, nil
+}
+
+func (s *Source) Name() string {
+    return "csv"
+}
+
+// 日時にタイムゾーンが無いので、設定のtimezoneで読む
+func (s *Source) Location() *time.Location {
+    return s.timezone
+}
+
+// 過去のデータを取り込むためのものなので、初回の同期ではファイルの全ての行を取得する (measurement.HistorySource)
+func (s *Source) FullHistory() bool {
+    return true
+}
+
+// 測定日時がfrom〜toの行を返す (measurement.Source)
+func (s *Source) Measurements(from time.Time, to time.Time) ([]measurement.BodyMeasurement, error) {
+    f, err := os.Open(s.conf.Path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    rows, err := s.Read(f)
+    if err != nil {
+        return nil, err
+    }
+
+    var ret []measurement.BodyMeasurement
+    for _, m := range rows {
+        if m.Date.Before(from) || m.Date.After(to) {
+            continue
+        }
+        ret = append(ret, m)
+    }
+    measurement.SortByDate(ret)
+    return ret, nil
+}
+
+func (s *Source) Read(r io.Reader) ([]measurement.BodyMeasurement, error) {
+    cr := csv.NewReader(r)
+    if s.conf.Delimiter != "" {
+        cr.Comma = []rune(s.conf.Delimiter)[0]
+    }
+    cr.FieldsPerRecord = -1
+
+    var columns map[string]int
+    var ret []measurement.BodyMeasurement
+    for line := 1; ; line++ {
+        record, err := cr.Read()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, err
+        }
+
+        if columns == nil {
+            columns, err = s.columns(record)
+            if err != nil {
+                return nil, err
+            }
+            if !s.conf.NoHeader {
+                continue
+            }
+        }
+
+        m, err := s.parse_record(columns, record)
+        if err != nil {
+            return nil, errors.New(fmt.Sprintf("[csv]%s:%d: %s", s.conf.Path, line, err))
+        }
+        if m == nil {
+            continue
+        }
+        ret = append(ret, *m)
+    }
+
+    s.Logger.Debug(fmt.Sprintf("[csv]Read %d rows from %s", len(ret), s.conf.Path))
+    return ret, nil
+}
+
+// 設定の列名から列番号を引けるようにする
+func (s *Source) columns(header []string) (map[string]int, error) {
+    columns := make(map[string]int)
+    for _, name := range []string{s.conf.DateColumn, s.conf.TimeColumn, s.conf.WeightColumn, s.conf.BodyFatColumn, s.conf.ModelColumn} {
+        if name == "" {
+            continue
+        }
+        if s.conf.NoHeader {
+            n, err := strconv.Atoi(name)
+            if err != nil || n < 1 {
+                return nil, errors.New(fmt.Sprintf("[csv]Column must be a number from 1 without header: %s", name))
+            }
+            columns[name] = n - 1
+            continue
+        }
+
+        found := false
+        for i, h := range header {
+            if strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")) == name {
+                columns[name] = i
+                found = true
+                break
+            }
+        }
+        if !found {
+            return nil, errors.New(fmt.Sprintf("[csv]Column not found: %s", name))
+        }
+    }
+    return columns, nil
+}
+
+func field(columns map[string]int, record []string, name string) string {
+    i, ok := columns[name]
+    if name == "" || !ok || i >= len(record) {
+        return ""
+    }
+    return strings.TrimSpace(record[i])
+}
+
+func parse_optional_float(value string) (float64, error) {
+    if value == "" || value == "-" {
+        return 0, nil
+    }
+    return strconv.ParseFloat(value, 64)
+}
+
+// 体重が空の行はnilを返す
+func (s *Source) parse_record(columns map[string]int, record []string) (*measurement.BodyMeasurement, error) {
+    weight, err := parse_optional_float(field(columns, record, s.conf.WeightColumn))
+    if err != nil {
+        return nil, err
+    }
+    if weight == 0 {
+        return nil, nil
+    }
+    if s.conf.WeightUnit == "lb" {
+        weight = weight * pound_to_kg
+    }
+
+    fat, err := parse_optional_float(field(columns, record, s.conf.BodyFatColumn))
+    if err != nil {
+        return nil, err
+    }
+
+    date, err := s.parse_date(field(columns, record, s.conf.DateColumn), field(columns, record, s.conf.TimeColumn))
+    if err != nil {
+        return nil, err
+    }
+
+    return &measurement.BodyMeasurement{
+        Date: date,
+        Weight: weight,
+        BodyFat: fat,
+        Model: field(columns, record, s.conf.ModelColumn),
+        Source: "CSV",
+    }, nil
+}
+
+func (s *Source) parse_date(date string, clock string) (time.Time, error) {
+    if s.conf.DateFormat == "unix" {
+        n, err := strconv.ParseInt(date, 10, 64)
+        if err != nil {
+            return time.Time{}, err
+        }
+        return time.Unix(n, 0).In(s.timezone), nil
+    }
+    if s.conf.TimeColumn != "" {
+        date = date + " " + clock
+    }
+    return time.ParseInLocation(s.conf.DateFormat, date, s.timezone)
+}
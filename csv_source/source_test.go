@@ -0,0 +1,184 @@
+package csv_source
+
+import (
+    "io"
+    "log/slog"
+    "math"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func new_test_source(t *testing.T, conf Config) *Source {
+    t.Helper()
+    if conf.Timezone == "" {
+        conf.Timezone = "Asia/Tokyo"
+    }
+    s, err := NewSource(conf, slog.New(slog.NewTextHandler(io.Discard, nil)))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return s
+}
+
+func TestRead(t *testing.T) {
+    jst, err := time.LoadLocation("Asia/Tokyo")
+    if err != nil {
+        t.Fatal(err)
+    }
+    type row struct {
+        date time.Time
+        weight float64
+        fat float64
+        model string
+    }
+    tests := []struct {
+        name string
+        conf Config
+        data string
+        want []row
+    }{
+        {
+            // Excelで保存したCSVの先頭のBOMは列名に含めない
+            name: "bom and header",
+            conf: Config{DateColumn: "Date", DateFormat: "2006/01/02 15:04", WeightColumn: "Weight(kg)", BodyFatColumn: "Body Fat(%)", ModelColumn: "Model"},
+            data: "\ufeffDate,Weight(kg),Body Fat(%),Model\n2024/01/02 07:00,60.5,22.1,RD-907\n2024/01/03 07:05,60.3,-,\n",
+            want: []row{
+                {time.Date(2024, 1, 2, 7, 0, 0, 0, jst), 60.5, 22.1, "RD-907"},
+                {time.Date(2024, 1, 3, 7, 5, 0, 0, jst), 60.3, 0, ""},
+            },
+        },
+        {
+            name: "no header",
+            conf: Config{NoHeader: true, Delimiter: ";", DateColumn: "2", DateFormat: "2006-01-02 15:04", WeightColumn: "1"},
+            data: "60.5;2024-01-02 07:00\n61;2024-01-03 07:05\n",
+            want: []row{
+                {time.Date(2024, 1, 2, 7, 0, 0, 0, jst), 60.5, 0, ""},
+                {time.Date(2024, 1, 3, 7, 5, 0, 0, jst), 61, 0, ""},
+            },
+        },
+        {
+            name: "pound",
+            conf: Config{DateColumn: "date", DateFormat: "2006-01-02 15:04", WeightColumn: "weight", WeightUnit: "lb"},
+            data: "date,weight\n2024-01-02 07:00,150\n",
+            want: []row{
+                {time.Date(2024, 1, 2, 7, 0, 0, 0, jst), 68.0388555, 0, ""},
+            },
+        },
+        {
+            name: "unix time",
+            conf: Config{DateColumn: "time", DateFormat: "unix", WeightColumn: "weight"},
+            data: "time,weight\n1704146400,60.5\n",
+            want: []row{
+                {time.Date(2024, 1, 2, 7, 0, 0, 0, jst), 60.5, 0, ""},
+            },
+        },
+        {
+            // 日付と時刻が別の列の場合は"日付 時刻"として読む
+            name: "time column",
+            conf: Config{DateColumn: "date", TimeColumn: "time", DateFormat: "02.01.2006 15:04", WeightColumn: "weight", Timezone: "UTC"},
+            data: "date,time,weight\n02.01.2024,07:00,60.5\n",
+            want: []row{
+                {time.Date(2024, 1, 2, 7, 0, 0, 0, time.UTC), 60.5, 0, ""},
+            },
+        },
+        {
+            // 体重が空の行(体脂肪率だけの測定など)は読み飛ばす
+            name: "empty weight",
+            conf: Config{DateColumn: "date", DateFormat: "2006-01-02 15:04", WeightColumn: "weight", BodyFatColumn: "fat"},
+            data: "date,weight,fat\n2024-01-02 07:00,,22.1\n2024-01-03 07:00,-,22.0\n2024-01-04 07:00,60.5,21.9\n2024-01-05 07:00\n",
+            want: []row{
+                {time.Date(2024, 1, 4, 7, 0, 0, 0, jst), 60.5, 21.9, ""},
+            },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            s := new_test_source(t, tt.conf)
+            data, err := s.Read(strings.NewReader(tt.data))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(data) != len(tt.want) {
+                t.Fatalf("got %d rows, want %d: %v", len(data), len(tt.want), data)
+            }
+            for i, w := range tt.want {
+                d := data[i]
+                if !d.Date.Equal(w.date) || d.Date.Location().String() != w.date.Location().String() {
+                    t.Errorf("rows[%d].Date = %s, want %s", i, d.Date, w.date)
+                }
+                if math.Abs(d.Weight - w.weight) > 1e-6 || d.BodyFat != w.fat || d.Model != w.model || d.Source != "CSV" {
+                    t.Errorf("rows[%d] = %s, want %+v", i, &d, w)
+                }
+            }
+        })
+    }
+}
+
+func TestReadInvalid(t *testing.T) {
+    tests := []struct {
+        name string
+        conf Config
+        data string
+    }{
+        {"missing column", Config{DateColumn: "date", DateFormat: "2006-01-02", WeightColumn: "weight"}, "date,kg\n2024-01-02,60\n"},
+        {"column name without header", Config{NoHeader: true, DateColumn: "date", DateFormat: "2006-01-02", WeightColumn: "2"}, "2024-01-02,60\n"},
+        {"date", Config{DateColumn: "date", DateFormat: "2006-01-02", WeightColumn: "weight"}, "date,weight\n01/02/2024,60\n"},
+        {"weight", Config{DateColumn: "date", DateFormat: "2006-01-02", WeightColumn: "weight"}, "date,weight\n2024-01-02,sixty\n"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            s := new_test_source(t, tt.conf)
+            _, err := s.Read(strings.NewReader(tt.data))
+            if err == nil {
+                t.Errorf("expected error")
+            }
+        })
+    }
+}
+
+func TestMeasurementsRange(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "history.csv")
+    data := "date,weight\n2024-01-03 07:00,60.3\n2024-01-01 07:00,60.1\n2024-01-02 07:00,60.2\n2024-01-04 07:00,60.4\n"
+    err := os.WriteFile(path, []byte(data), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    s := new_test_source(t, Config{Path: path, DateColumn: "date", DateFormat: "2006-01-02 15:04", WeightColumn: "weight", Timezone: "UTC"})
+
+    tests := []struct {
+        name string
+        from time.Time
+        to time.Time
+        want []float64
+    }{
+        // 初回の同期(ゼロ値から)は全ての行を返す
+        {"all", time.Time{}, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), []float64{60.1, 60.2, 60.3, 60.4}},
+        // from, toと同じ日時の行も含む
+        {"inclusive", time.Date(2024, 1, 2, 7, 0, 0, 0, time.UTC), time.Date(2024, 1, 3, 7, 0, 0, 0, time.UTC), []float64{60.2, 60.3}},
+        {"after", time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            ms, err := s.Measurements(tt.from, tt.to)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(ms) != len(tt.want) {
+                t.Fatalf("got %d measurements, want %d", len(ms), len(tt.want))
+            }
+            // 日時順に並べる
+            for i, w := range tt.want {
+                if ms[i].Weight != w {
+                    t.Errorf("measurements[%d] = %s, want weight %.1f", i, &ms[i], w)
+                }
+            }
+        })
+    }
+    if !s.FullHistory() {
+        t.Errorf("csv source must import the full history")
+    }
+}
@@ -0,0 +1,140 @@
+package export
+
+import (
+    "bytes"
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "time"
+)
+
+const (
+    FormatCSV = "csv"
+    FormatJSON = "json"
+    FormatNDJSON = "ndjson"
+)
+
+var SupportFormats = []string{FormatCSV, FormatJSON, FormatNDJSON}
+
+// 列の順番を保ったまま各形式に書き出すための表
+type Table struct {
+    Columns []string
+    Rows [][]any
+}
+
+func (t *Table) Append(row ...any) {
+    t.Rows = append(t.Rows, row)
+}
+
+func Write(w io.Writer, format string, t *Table) error {
+    switch format {
+    case FormatCSV:
+        return write_csv(w, t)
+    case FormatJSON:
+        return write_json(w, t)
+    case FormatNDJSON:
+        return write_ndjson(w, t)
+    }
+    return errors.New(fmt.Sprintf("Unknown format: %s. Support formats are %s", format, SupportFormats))
+}
+
+func format_value(v any) string {
+    switch v := v.(type) {
+    case nil:
+        return ""
+    case string:
+        return v
+    case time.Time:
+        return v.Format(time.RFC3339)
+    case float64:
+        return fmt.Sprintf("%g", v)
+    }
+    return fmt.Sprint(v)
+}
+
+func write_csv(w io.Writer, t *Table) error {
+    cw := csv.NewWriter(w)
+    err := cw.Write(t.Columns)
+    if err != nil {
+        return err
+    }
+    for _, row := range t.Rows {
+        var record []string
+        for _, v := range row {
+            record = append(record, format_value(v))
+        }
+        err = cw.Write(record)
+        if err != nil {
+            return err
+        }
+    }
+    cw.Flush()
+    return cw.Error()
+}
+
+// encoding/jsonのmapはキーがソートされてしまうので、列の順番で組み立てる
+func marshal_row(columns []string, row []any) ([]byte, error) {
+    var buf bytes.Buffer
+    buf.WriteByte('{')
+    for i, col := range columns {
+        if i > 0 {
+            buf.WriteByte(',')
+        }
+        key, err := json.Marshal(col)
+        if err != nil {
+            return nil, err
+        }
+        v := row[i]
+        if t, ok := v.(time.Time); ok {
+            v = format_value(t)
+        }
+        value, err := json.Marshal(v)
+        if err != nil {
+            return nil, err
+        }
+        buf.Write(key)
+        buf.WriteByte(':')
+        buf.Write(value)
+    }
+    buf.WriteByte('}')
+    return buf.Bytes(), nil
+}
+
+func write_json(w io.Writer, t *Table) error {
+    _, err := io.WriteString(w, "[\n")
+    if err != nil {
+        return err
+    }
+    for i, row := range t.Rows {
+        data, err := marshal_row(t.Columns, row)
+        if err != nil {
+            return err
+        }
+        sep := ",\n"
+        if i == len(t.Rows) - 1 {
+            sep = "\n"
+        }
+        _, err = fmt.Fprintf(w, "  %s%s", data, sep)
+        if err != nil {
+            return err
+        }
+    }
+    _, err = io.WriteString(w, "]\n")
+    return err
+}
+
+func write_ndjson(w io.Writer, t *Table) error {
+    for _, row := range t.Rows {
+        data, err := marshal_row(t.Columns, row)
+        if err != nil {
+            return err
+        }
+        _, err = fmt.Fprintf(w, "%s\n", data)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
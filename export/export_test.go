@@ -0,0 +1,112 @@
+package export
+
+import (
+    "bytes"
+    "encoding/json"
+    "strings"
+    "testing"
+    "time"
+)
+
+func test_table() *Table {
+    loc := time.FixedZone("JST", 9 * 60 * 60)
+    t := &Table{Columns: []string{"date", "weight", "body_fat", "model"}}
+    t.Append(time.Date(2024, 1, 2, 7, 0, 0, 0, loc), 60.5, 22.1, "RD-907")
+    // 測定されていない項目はnil
+    t.Append(time.Date(2024, 1, 3, 7, 5, 0, 0, loc), 60.25, nil, "BC-768, \"mini\"")
+    return t
+}
+
+func TestWriteCSV(t *testing.T) {
+    var buf bytes.Buffer
+    err := Write(&buf, FormatCSV, test_table())
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := "date,weight,body_fat,model\n" +
+        "2024-01-02T07:00:00+09:00,60.5,22.1,RD-907\n" +
+        "2024-01-03T07:05:00+09:00,60.25,,\"BC-768, \"\"mini\"\"\"\n"
+    if buf.String() != want {
+        t.Errorf("csv =\n%s\nwant\n%s", buf.String(), want)
+    }
+}
+
+func TestWriteJSON(t *testing.T) {
+    var buf bytes.Buffer
+    err := Write(&buf, FormatJSON, test_table())
+    if err != nil {
+        t.Fatal(err)
+    }
+    // キーは列の順番のまま、空の値はnull
+    want := "[\n" +
+        "  {\"date\":\"2024-01-02T07:00:00+09:00\",\"weight\":60.5,\"body_fat\":22.1,\"model\":\"RD-907\"},\n" +
+        "  {\"date\":\"2024-01-03T07:05:00+09:00\",\"weight\":60.25,\"body_fat\":null,\"model\":\"BC-768, \\\"mini\\\"\"}\n" +
+        "]\n"
+    if buf.String() != want {
+        t.Errorf("json =\n%s\nwant\n%s", buf.String(), want)
+    }
+    var rows []map[string]any
+    if err := json.Unmarshal(buf.Bytes(), &rows); err != nil || len(rows) != 2 {
+        t.Errorf("invalid json: %v", err)
+    }
+}
+
+func TestWriteNDJSON(t *testing.T) {
+    var buf bytes.Buffer
+    err := Write(&buf, FormatNDJSON, test_table())
+    if err != nil {
+        t.Fatal(err)
+    }
+    // 1行に1件で、最後の行も改行で終わる
+    if !strings.HasSuffix(buf.String(), "}\n") {
+        t.Errorf("ndjson does not end with a newline: %q", buf.String())
+    }
+    lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+    want := []string{
+        "{\"date\":\"2024-01-02T07:00:00+09:00\",\"weight\":60.5,\"body_fat\":22.1,\"model\":\"RD-907\"}",
+        "{\"date\":\"2024-01-03T07:05:00+09:00\",\"weight\":60.25,\"body_fat\":null,\"model\":\"BC-768, \\\"mini\\\"\"}",
+    }
+    if len(lines) != len(want) {
+        t.Fatalf("lines = %q", lines)
+    }
+    for i, w := range want {
+        if lines[i] != w {
+            t.Errorf("lines[%d] = %s, want %s", i, lines[i], w)
+        }
+        var row map[string]any
+        if err := json.Unmarshal([]byte(lines[i]), &row); err != nil {
+            t.Errorf("lines[%d] is not json: %s", i, err)
+        }
+    }
+}
+
+func TestWriteEmpty(t *testing.T) {
+    tests := []struct {
+        format string
+        want string
+    }{
+        {FormatCSV, "date,weight,body_fat,model\n"},
+        {FormatJSON, "[\n]\n"},
+        {FormatNDJSON, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.format, func(t *testing.T) {
+            var buf bytes.Buffer
+            err := Write(&buf, tt.format, &Table{Columns: []string{"date", "weight", "body_fat", "model"}})
+            if err != nil {
+                t.Fatal(err)
+            }
+            if buf.String() != tt.want {
+                t.Errorf("output = %q, want %q", buf.String(), tt.want)
+            }
+        })
+    }
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+    var buf bytes.Buffer
+    err := Write(&buf, "xml", test_table())
+    if err == nil || buf.Len() != 0 {
+        t.Errorf("err = %v, output = %q", err, buf.String())
+    }
+}
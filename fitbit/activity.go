@@ -0,0 +1,118 @@
+package fitbit
+
+import (
+    "strings"
+    "fmt"
+    "net/url"
+    "net/http"
+    "io/ioutil"
+    "encoding/json"
+    "errors"
+    "time"
+)
+
+const ActivityIdWalk = 90013
+
+type ActivityLogResponse struct {
+    Activities []struct {
+        ActivityId int64 `json:"activityId"`
+        LogId int64 `json:"logId"`
+        Name string `json:"name"`
+        StartTime string `json:"startTime"`
+        Duration int64 `json:"duration"`
+        Steps int64 `json:"steps"`
+        Distance float64 `json:"distance"`
+        Calories int64 `json:"calories"`
+    } `json:"activities"`
+}
+
+func (c *Client) GetActivityLog(date time.Time) (*ActivityLogResponse, error) {
+    u, err := url.Parse(c.url)
+    if err != nil {
+        return nil, err
+    }
+
+    _path := "/1/user/[user-id]/activities/date/[date].json"
+    _path = strings.Replace(_path, "[user-id]", c.auth.token.User_id, -1)
+    _path = strings.Replace(_path, "[date]", date.Format("2006-01-02"), -1)
+    u.Path = _path
+
+    c.logger.Debug(fmt.Sprintf("[fitbit]Get activity log: %s", u.String()))
+
+    client := &http.Client{}
+    req, err := http.NewRequest("GET", u.String(), nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Authorization", "Bearer " + c.auth.token.Access_token)
+    req.Header.Set("accept", "application/json")
+    req.Header.Set("accept-language", "ja_JP")
+    req.Header.Set("accept-locale", "ja_JP")
+
+    resp, err := client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    body, _ := ioutil.ReadAll(resp.Body)
+    if resp.StatusCode != 200 {
+        return nil, errors.New(fmt.Sprintf("[fitbit]Failed to get activity log: (%d) %s", resp.StatusCode, body))
+    }
+
+    activity_log := ActivityLogResponse{}
+    c.logger.Debug(fmt.Sprintf("[fitbit]Response: %s", body))
+    err = json.Unmarshal(body, &activity_log)
+    if err != nil {
+        return nil, err
+    }
+
+    return &activity_log, nil
+}
+
+// 歩数を歩行(Walk)のアクティビティとして記録する
+// caloriesが0の場合はFitbit側で計算させる
+func (c *Client) CreateWalkLog(start time.Time, duration time.Duration, steps int64, calories int64) error {
+    u, err := url.Parse(c.url)
+    if err != nil {
+        return err
+    }
+
+    u.Path = "/1/user/[user-id]/activities.json"
+    u.Path = strings.Replace(u.Path, "[user-id]", c.auth.token.User_id, -1)
+
+    q := u.Query()
+    q.Set("activityId", fmt.Sprintf("%d", ActivityIdWalk))
+    q.Set("date", start.Format("2006-01-02"))
+    q.Set("startTime", start.Format("15:04"))
+    q.Set("durationMillis", fmt.Sprintf("%d", duration.Milliseconds()))
+    q.Set("distance", fmt.Sprintf("%d", steps))
+    q.Set("distanceUnit", "Steps")
+    if calories > 0 {
+        q.Set("manualCalories", fmt.Sprintf("%d", calories))
+    }
+    u.RawQuery = q.Encode()
+
+    client := &http.Client{}
+    req, err := http.NewRequest("POST", u.String(), nil)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Authorization", "Bearer " + c.auth.token.Access_token)
+    req.Header.Set("accept", "application/json")
+    req.Header.Set("accept-language", "ja_JP")
+    req.Header.Set("accept-locale", "ja_JP")
+
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != 201 {
+        body, _ := ioutil.ReadAll(resp.Body)
+        return errors.New(fmt.Sprintf("[fitbit]Failed to create activity log: (%d) %s", resp.StatusCode, body))
+    }
+
+    return nil
+}
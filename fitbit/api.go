@@ -2,6 +2,7 @@ package fitbit
 
 import (
     "strings"
+    "encoding/base64"
     "fmt"
     "net/url"
     "net/http"
@@ -10,7 +11,9 @@ import (
     "encoding/json"
     "errors"
     "time"
+    "sort"
     "log/slog"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
 )
 
 type Auth struct {
@@ -31,7 +34,17 @@ type Token struct {
     Token_type string `json:"token_type"`
     User_id string `json:"user_id"`
 
-    Create_date int64 `json:"create_date", omitempty`
+    Create_date int64 `json:"create_date,omitempty"`
+}
+
+
+type ProfileResponse struct {
+    User struct {
+        DisplayName string `json:"displayName"`
+        Height float64 `json:"height"`
+        Timezone string `json:"timezone"`
+        Weight float64 `json:"weight"`
+    } `json:"user"`
 }
 
 
@@ -55,10 +68,20 @@ type WeightLogResponse struct {
     } `json:"weight"`
 }
 
+// WeightLogのSource (このツールなどAPIから記録したものは"API")
+const (
+    SourceAPI = "API"
+    SourceAria = "Aria"
+    SourceWeb = "Web"
+)
+
 type WeightLog struct {
     Date time.Time
     Weight float64
     Fat float64
+    Bmi float64
+    LogId int64
+    Source string
 }
 
 func (w *WeightLogResponse) ToWeightLog(timezone *time.Location) ([]WeightLog, error) {
@@ -69,7 +92,7 @@ func (w *WeightLogResponse) ToWeightLog(timezone *time.Location) ([]WeightLog, e
             return nil, err
         }
 
-        weight_logs = append(weight_logs, WeightLog{Date: date, Weight: wl.Weight, Fat: wl.Fat})
+        weight_logs = append(weight_logs, WeightLog{Date: date, Weight: wl.Weight, Fat: wl.Fat, Bmi: wl.Bmi, LogId: wl.LogId, Source: wl.Source})
     }
 
     return weight_logs, nil
@@ -80,6 +103,36 @@ func (w *WeightLog) String() string {
 }
 
 
+// Create_dateが0の場合(手動で作成したトークン)は有効期限が分からない
+func (t *Token) ExpiresAt() (time.Time, bool) {
+    if t.Create_date == 0 {
+        return time.Time{}, false
+    }
+    return time.Unix(t.Create_date + t.Expires_in, 0), true
+}
+
+func (t *Token) IsTokenExpired() bool {
+    expires_at, ok := t.ExpiresAt()
+    if !ok {
+        return false
+    }
+    return expires_at.Before(time.Now())
+}
+
+func (t *Token) HasScope(scope string) bool {
+    return contains(strings.Fields(t.Scope), scope)
+}
+
+func contains(arr []string, str string) bool {
+    for _, a := range arr {
+        if a == str {
+            return true
+        }
+    }
+    return false
+}
+
+
 func NewAuth(url string, client_id string, client_secret string, dump_filepath string) *Auth {
     auth := Auth{
         url: url,
@@ -130,6 +183,10 @@ func (a *Auth) InitToken() error {
 }
 
 
+func (a *Auth) Token() *Token {
+    return a.token
+}
+
 func (a *Auth) LoadToken() error {
     data, err := ioutil.ReadFile(a.dump_filepath)
     if err != nil {
@@ -150,7 +207,7 @@ func (a *Auth) DumpToken() error {
         return err
     }
 
-    f, err := os.OpenFile(a.dump_filepath, os.O_CREATE|os.O_WRONLY, 0644)
+    f, err := os.OpenFile(a.dump_filepath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
     if err != nil {
         return err
     }
@@ -206,21 +263,105 @@ func (a *Auth) RefreshToken() error {
     return nil
 }
 
+// リフレッシュトークンを失効させる(発行済みのアクセストークンも同時に失効する)
+// アクセストークンは8時間で期限切れになるので、logoutの時点でも有効なリフレッシュトークンを送る
+func (a *Auth) RevokeToken() error {
+    u, err := url.Parse(a.url)
+    if err != nil {
+        return err
+    }
+
+    u.Path = "/oauth2/revoke"
+    q := url.Values{}
+    q.Set("token", a.token.Refresh_token)
+
+    req, err := http.NewRequest("POST", u.String(), strings.NewReader(q.Encode()))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.Header.Set("Authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte(a.client_id + ":" + a.client_secret)))
+
+    client := &http.Client{}
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != 200 {
+        body, _ := ioutil.ReadAll(resp.Body)
+        return errors.New(fmt.Sprintf("[fitbit]Failed to revoke token: (%d) %s", resp.StatusCode, body))
+    }
+
+    return nil
+}
+
+// RevokeTokenで失効させた後のトークンファイルを削除する (archiveの場合は日時付きのファイル名で残す)
+func (a *Auth) RemoveToken(archive bool) (string, error) {
+    return measurement.RemoveFile(a.dump_filepath, archive)
+}
+
 
 func NewClient(url string, auth *Auth, logger *slog.Logger, timezone *time.Location) *Client {
     return &Client{url: url, auth: auth, logger:logger, Timezone: timezone}
 }
 
 func (c *Client) GetWeightLog(date time.Time) (*WeightLogResponse, error) {
-    u, err := url.Parse(c.url)
-    if err != nil {
-        return nil, err
-    }
-    
     _path := "/1/user/[user-id]/body/log/weight/date/[date].json"
     _path = strings.Replace(_path, "[user-id]", c.auth.token.User_id, -1)
     _path = strings.Replace(_path, "[date]", date.Format("2006-01-02"), -1)
 
+    return c.get_weight_log(_path)
+}
+
+// 1回のリクエストで取得できるのは31日分まで
+const MaxWeightLogRange = 31
+
+// base_date〜end_date(日付、両端を含む)の記録を返す
+func (c *Client) GetWeightLogRange(base_date time.Time, end_date time.Time) (*WeightLogResponse, error) {
+    _path := "/1/user/[user-id]/body/log/weight/date/[base-date]/[end-date].json"
+    _path = strings.Replace(_path, "[user-id]", c.auth.token.User_id, -1)
+    _path = strings.Replace(_path, "[base-date]", base_date.Format("2006-01-02"), -1)
+    _path = strings.Replace(_path, "[end-date]", end_date.Format("2006-01-02"), -1)
+
+    return c.get_weight_log(_path)
+}
+
+// from〜toの日付の記録をMaxWeightLogRange日ずつに分けて取得し、日時順に返す
+func (c *Client) GetWeightLogs(from time.Time, to time.Time) ([]WeightLog, error) {
+    // 時刻で比べると、fromの時刻がtoより遅い場合に最後の日を取得しないので日付だけにする
+    from = from.In(c.Timezone)
+    to = to.In(c.Timezone)
+    from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, c.Timezone)
+    to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, c.Timezone)
+
+    var ret []WeightLog
+    for base := from; !base.After(to); base = base.AddDate(0, 0, MaxWeightLogRange) {
+        end := base.AddDate(0, 0, MaxWeightLogRange - 1)
+        if end.After(to) {
+            end = to
+        }
+        resp, err := c.GetWeightLogRange(base, end)
+        if err != nil {
+            return nil, err
+        }
+        weight_logs, err := resp.ToWeightLog(c.Timezone)
+        if err != nil {
+            return nil, err
+        }
+        ret = append(ret, weight_logs...)
+    }
+    sort.SliceStable(ret, func(i, j int) bool { return ret[i].Date.Before(ret[j].Date) })
+
+    return ret, nil
+}
+
+func (c *Client) get_weight_log(_path string) (*WeightLogResponse, error) {
+    u, err := url.Parse(c.url)
+    if err != nil {
+        return nil, err
+    }
     u.Path = _path
 
     c.logger.Debug(fmt.Sprintf("[fitbit]Get weight log: %s", u.String()))
@@ -249,10 +390,53 @@ func (c *Client) GetWeightLog(date time.Time) (*WeightLogResponse, error) {
     weight_log := WeightLogResponse{}
     c.logger.Debug(fmt.Sprintf("[fitbit]Response: %s", body))
     err = json.Unmarshal(body, &weight_log)
+    if err != nil {
+        return nil, err
+    }
 
     return &weight_log, nil
 }
 
+func (c *Client) GetProfile() (*ProfileResponse, error) {
+    u, err := url.Parse(c.url)
+    if err != nil {
+        return nil, err
+    }
+
+    u.Path = "/1/user/-/profile.json"
+
+    c.logger.Debug(fmt.Sprintf("[fitbit]Get profile: %s", u.String()))
+
+    client := &http.Client{}
+    req, err := http.NewRequest("GET", u.String(), nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Authorization", "Bearer " + c.auth.token.Access_token)
+    req.Header.Set("accept", "application/json")
+    req.Header.Set("accept-language", "ja_JP")
+    req.Header.Set("accept-locale", "ja_JP")
+
+    resp, err := client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    body, _ := ioutil.ReadAll(resp.Body)
+    if resp.StatusCode != 200 {
+        return nil, errors.New(fmt.Sprintf("[fitbit]Failed to get profile: (%d) %s", resp.StatusCode, body))
+    }
+
+    profile := ProfileResponse{}
+    err = json.Unmarshal(body, &profile)
+    if err != nil {
+        return nil, err
+    }
+
+    return &profile, nil
+}
+
 func (c *Client) CreateWeightAndFatLog(date time.Time, weight float64, fat float64) error {
     err := c.CreateWeightLog(date, weight)
     if err != nil {
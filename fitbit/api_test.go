@@ -0,0 +1,181 @@
+package fitbit
+
+import (
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+// 体重の記録の取得先のパスを記録し、各日の7:00の記録を返すFitbit APIのスタブ
+func new_weight_log_server(t *testing.T, paths *[]string) *httptest.Server {
+    t.Helper()
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        *paths = append(*paths, r.URL.Path)
+        if r.Header.Get("Authorization") != "Bearer access" {
+            w.WriteHeader(401)
+            return
+        }
+        // /1/user/U/body/log/weight/date/<base>/<end>.json
+        parts := strings.Split(strings.TrimSuffix(r.URL.Path, ".json"), "/")
+        base, _ := time.Parse("2006-01-02", parts[len(parts) - 2])
+        end, _ := time.Parse("2006-01-02", parts[len(parts) - 1])
+        var logs []string
+        for d := base; !d.After(end); d = d.AddDate(0, 0, 1) {
+            logs = append(logs, fmt.Sprintf(`{"date": "%s", "time": "07:00:00", "weight": 60.5, "fat": 20.1, "logId": %d, "source": "API"}`, d.Format("2006-01-02"), d.Unix()))
+        }
+        fmt.Fprintf(w, `{"weight": [%s]}`, strings.Join(logs, ","))
+    }))
+    t.Cleanup(srv.Close)
+    return srv
+}
+
+func new_test_client(url string, loc *time.Location) *Client {
+    auth := NewAuth(url, "id", "secret", "")
+    auth.token = &Token{Access_token: "access", User_id: "U"}
+    return NewClient(url, auth, slog.New(slog.NewTextHandler(io.Discard, nil)), loc)
+}
+
+func TestGetWeightLogs(t *testing.T) {
+    loc := time.FixedZone("JST", 9 * 60 * 60)
+    tests := []struct {
+        name string
+        from time.Time
+        to time.Time
+        paths []string
+        days int
+    }{
+        {
+            // fromの時刻がtoより遅くても最後の日を取得する
+            name: "from later in the day than to",
+            from: time.Date(2024, 1, 1, 14, 0, 0, 0, loc),
+            to: time.Date(2024, 2, 1, 10, 0, 0, 0, loc),
+            paths: []string{"2024-01-01/2024-01-31", "2024-02-01/2024-02-01"},
+            days: 32,
+        },
+        {
+            name: "single day",
+            from: time.Date(2024, 1, 1, 23, 0, 0, 0, loc),
+            to: time.Date(2024, 1, 1, 1, 0, 0, 0, loc),
+            paths: []string{"2024-01-01/2024-01-01"},
+            days: 1,
+        },
+        {
+            name: "exactly 31 days",
+            from: time.Date(2024, 3, 1, 0, 0, 0, 0, loc),
+            to: time.Date(2024, 3, 31, 23, 59, 0, 0, loc),
+            paths: []string{"2024-03-01/2024-03-31"},
+            days: 31,
+        },
+        {
+            // Fitbitのタイムゾーンの日付で区切る
+            name: "other timezone",
+            from: time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC),
+            to: time.Date(2024, 1, 2, 16, 0, 0, 0, time.UTC),
+            paths: []string{"2024-01-02/2024-01-03"},
+            days: 2,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var paths []string
+            srv := new_weight_log_server(t, &paths)
+            c := new_test_client(srv.URL, loc)
+
+            logs, err := c.GetWeightLogs(tt.from, tt.to)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(paths) != len(tt.paths) {
+                t.Fatalf("paths = %v, want %v", paths, tt.paths)
+            }
+            for i, p := range tt.paths {
+                if want := "/1/user/U/body/log/weight/date/" + p + ".json"; paths[i] != want {
+                    t.Errorf("paths[%d] = %s, want %s", i, paths[i], want)
+                }
+            }
+            if len(logs) != tt.days {
+                t.Fatalf("got %d logs, want %d", len(logs), tt.days)
+            }
+            for i := 1; i < len(logs); i++ {
+                if !logs[i - 1].Date.Before(logs[i].Date) {
+                    t.Errorf("logs are not sorted: %s, %s", logs[i - 1].Date, logs[i].Date)
+                }
+            }
+            if l := logs[0]; l.Date.Location() != loc || l.Date.Hour() != 7 || l.Weight != 60.5 || l.Fat != 20.1 || l.Source != SourceAPI {
+                t.Errorf("logs[0] = %s", &l)
+            }
+        })
+    }
+}
+
+func TestGetWeightLogsError(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(429)
+        w.Write([]byte(`{"errors": [{"errorType": "system"}]}`))
+    }))
+    defer srv.Close()
+
+    c := new_test_client(srv.URL, time.UTC)
+    _, err := c.GetWeightLogs(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC))
+    if err == nil || !strings.Contains(err.Error(), "429") {
+        t.Errorf("err = %v", err)
+    }
+}
+
+func TestRevokeToken(t *testing.T) {
+    var path, auth, content_type string
+    var form map[string][]string
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        path = r.URL.Path
+        auth = r.Header.Get("Authorization")
+        content_type = r.Header.Get("Content-Type")
+        if err := r.ParseForm(); err != nil {
+            t.Error(err)
+        }
+        form = r.PostForm
+    }))
+    defer srv.Close()
+
+    a := NewAuth(srv.URL, "id", "secret", "")
+    a.token = &Token{Access_token: "access", Refresh_token: "refresh", User_id: "U"}
+    err := a.RevokeToken()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if path != "/oauth2/revoke" {
+        t.Errorf("path = %s", path)
+    }
+    // base64("id:secret")
+    if auth != "Basic aWQ6c2VjcmV0" {
+        t.Errorf("Authorization = %q", auth)
+    }
+    if content_type != "application/x-www-form-urlencoded" {
+        t.Errorf("Content-Type = %q", content_type)
+    }
+    // 期限切れの可能性があるアクセストークンではなくリフレッシュトークンを送る
+    if len(form) != 1 || len(form["token"]) != 1 || form["token"][0] != "refresh" {
+        t.Errorf("form = %v", form)
+    }
+}
+
+func TestRevokeTokenError(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(401)
+        w.Write([]byte(`{"errors": [{"errorType": "invalid_client"}]}`))
+    }))
+    defer srv.Close()
+
+    a := NewAuth(srv.URL, "id", "wrong", "")
+    a.token = &Token{Access_token: "access", Refresh_token: "refresh"}
+    err := a.RevokeToken()
+    if err == nil || !strings.Contains(err.Error(), "401") {
+        t.Errorf("err = %v", err)
+    }
+}
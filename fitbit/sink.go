@@ -0,0 +1,72 @@
+package fitbit
+
+import (
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+func (c *Client) Name() string {
+    return "fitbit"
+}
+
+func (c *Client) Location() *time.Location {
+    return c.Timezone
+}
+
+// このツールが記録した体重は他のアプリ経由の記録と区別できるように"API"になる (measurement.SourcedSink)
+func (c *Client) WriteSource() string {
+    return SourceAPI
+}
+
+// dateと同じ日(Fitbitのタイムゾーン)の体重・体脂肪率の記録を返す (measurement.Sink)
+func (c *Client) Existing(date time.Time) ([]measurement.BodyMeasurement, error) {
+    resp, err := c.GetWeightLog(date.In(c.Timezone))
+    if err != nil {
+        return nil, err
+    }
+    weight_logs, err := resp.ToWeightLog(c.Timezone)
+    if err != nil {
+        return nil, err
+    }
+
+    var ret []measurement.BodyMeasurement
+    for _, wl := range weight_logs {
+        ret = append(ret, wl.ToBodyMeasurement())
+    }
+    return ret, nil
+}
+
+// from〜toの日付(Fitbitのタイムゾーン)の記録をMaxWeightLogRange日ずつまとめて取得する (measurement.RangeSink)
+func (c *Client) ExistingRange(from time.Time, to time.Time) ([]measurement.BodyMeasurement, error) {
+    return c.Measurements(from, to)
+}
+
+// 日時はFitbitのタイムゾーンに変換して記録する
+// 体脂肪率が無い(0の)場合は体重だけ記録する
+func (c *Client) Write(m measurement.BodyMeasurement) error {
+    date := m.Date.In(c.Timezone)
+    if m.BodyFat <= 0 {
+        return c.CreateWeightLog(date, m.Weight)
+    }
+    return c.CreateWeightAndFatLog(date, m.Weight, m.BodyFat)
+}
+
+func (w *WeightLog) ToBodyMeasurement() measurement.BodyMeasurement {
+    return measurement.BodyMeasurement{
+        Date: w.Date,
+        Weight: w.Weight,
+        BodyFat: w.Fat,
+        BMI: w.Bmi,
+        Source: w.Source,
+    }
+}
+
+// Fitbitのプロフィールの身長(cm)を返す。取得できない場合は0を返す
+func (c *Client) Height() float64 {
+    profile, err := c.GetProfile()
+    if err != nil {
+        c.logger.Warn("[fitbit]Failed to get profile: " + err.Error())
+        return 0
+    }
+    return profile.User.Height
+}
@@ -0,0 +1,22 @@
+package fitbit
+
+import (
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+// from〜toの日付(Fitbitのタイムゾーン)の体重・体脂肪率の記録を返す (measurement.Source)
+// Fitbitには登録日時が無いので日付単位で取得する (後から過去の日付で手入力された記録は取得できない場合がある)
+func (c *Client) Measurements(from time.Time, to time.Time) ([]measurement.BodyMeasurement, error) {
+    weight_logs, err := c.GetWeightLogs(from, to)
+    if err != nil {
+        return nil, err
+    }
+
+    var ret []measurement.BodyMeasurement
+    for _, wl := range weight_logs {
+        ret = append(ret, wl.ToBodyMeasurement())
+    }
+
+    return ret, nil
+}
@@ -9,9 +9,12 @@ import (
     "net/url"
     "encoding/json"
     "errors"
+    "math"
     "time"
     "strconv"
+    "strings"
     "log/slog"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
 )
 
 type Auth struct {
@@ -29,7 +32,7 @@ type Token struct {
     ExpiresIn int64 `json:"expires_in"`
     
     // metadata
-    Create_date int64 `json:"create_date", omitempty`
+    Create_date int64 `json:"create_date,omitempty"`
 }
 
 
@@ -38,6 +41,7 @@ type Client struct {
     auth *Auth
     Logger *slog.Logger
     Timezone *time.Location
+    Profile *Profile
 }
 
 
@@ -53,20 +57,79 @@ type InnerscanResponse struct {
     } `json:"data"`
 }
 
+// 体重・体脂肪率以外は機種によっては測定されない(0のまま)
 type InnerscanData struct {
     Date time.Time
     Weight float64
     BodyFat float64
+    MuscleMass float64
+    MuscleScore float64
+    VisceralFatLevel2 float64
+    VisceralFatLevel float64
+    BasalMetabolicRate float64
+    BodyAge float64
+    BoneMass float64
+    Model string
 }
 
 func (d *InnerscanData) String() string {
-    return fmt.Sprintf("(%s)Weight: %f, BodyFat: %f", d.Date, d.Weight, d.BodyFat)
+    return fmt.Sprintf("(%s)Weight: %f, BodyFat: %f, Model: %s", d.Date, d.Weight, d.BodyFat, d.Model)
 }
 
+// 同じ日時に別の体組成計で測定したデータが衝突しないように、日時と機種をキーにする
 type InnerscanDataMap map[string]*InnerscanData
 
+func innerscan_key(date string, model string) string {
+    return date + "/" + model
+}
+
+// HealthPlanetに登録されている利用者の情報
+type Profile struct {
+    BirthDate time.Time
+    Height float64 // cm
+    Sex string // male / female
+}
+
+func (p *Profile) String() string {
+    return fmt.Sprintf("BirthDate: %s, Height: %.1fcm, Sex: %s", p.BirthDate.Format("2006-01-02"), p.Height, p.Sex)
+}
+
+// 小数点以下1桁に丸めたBMIを返す。身長が分からない場合は0を返す
+func (p *Profile) BMI(weight float64) float64 {
+    if p == nil || p.Height <= 0 {
+        return 0
+    }
+    h := p.Height / 100
+    return math.Round(weight / (h * h) * 10) / 10
+}
+
 const TokenRefreshThreshold = 60 * 60 * 24 * 7 // 1 week
 
+const (
+    TagWeight = "6021" // 体重 (kg)
+    TagBodyFat = "6022" // 体脂肪率 (%)
+    TagMuscleMass = "6023" // 筋肉量 (kg)
+    TagMuscleScore = "6024" // 筋肉スコア
+    TagVisceralFatLevel2 = "6025" // 内臓脂肪レベル2 (小数点有り)
+    TagVisceralFatLevel = "6026" // 内臓脂肪レベル (小数点無し)
+    TagBasalMetabolicRate = "6027" // 基礎代謝量 (kcal)
+    TagBodyAge = "6028" // 体内年齢 (才)
+    TagBoneMass = "6029" // 推定骨量 (kg)
+)
+
+var InnerscanTags = []string{
+    TagWeight, TagBodyFat, TagMuscleMass, TagMuscleScore, TagVisceralFatLevel2,
+    TagVisceralFatLevel, TagBasalMetabolicRate, TagBodyAge, TagBoneMass,
+}
+
+// from, toをどの日時で絞り込むか
+type DateType int
+
+const (
+    DateTypeRegistration DateType = 0 // 登録日時 (HealthPlanetにアップロードされた日時)
+    DateTypeMeasurement DateType = 1 // 測定日時
+)
+
 func NewAuth(url string, client_id string, client_secret string, dump_filepath string, logger *slog.Logger) *Auth {
     auth := Auth{
         url: url,
@@ -87,7 +150,7 @@ func (a *Auth) DumpToken() error {
         return err
     }
 
-    f, err := os.OpenFile(a.dump_filepath, os.O_CREATE|os.O_WRONLY, 0644)
+    f, err := os.OpenFile(a.dump_filepath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
     if err != nil {
         return err
     }
@@ -101,6 +164,14 @@ func (a *Auth) DumpToken() error {
     return nil
 }
 
+func (t *Token) ExpiresAt() time.Time {
+    return time.Unix(t.Create_date + t.ExpiresIn, 0)
+}
+
+func (t *Token) RefreshAt() time.Time {
+    return time.Unix(t.Create_date + t.ExpiresIn - TokenRefreshThreshold, 0)
+}
+
 func (t *Token) IsTokenExpired() bool {
     return t.Create_date + t.ExpiresIn < time.Now().Unix()
 }
@@ -111,6 +182,10 @@ func (t *Token) IsTokenNeedRefresh() bool {
 
 
 
+func (a *Auth) Token() *Token {
+    return a.token
+}
+
 func (a *Auth) LoadToken() error {
     data, err := ioutil.ReadFile(a.dump_filepath)
     if err != nil {
@@ -198,11 +273,19 @@ func (a *Auth) GetToken(code string) (*Token, error) {
 }
 
 func (a *Auth) RefreshToken() error{
+    if a.token == nil {
+        return errors.New("[HealthPlanet]Token is not initialized")
+    }
     if !a.token.IsTokenNeedRefresh(){
         return nil
     }
     fmt.Println("Token is need to refresh")
 
+    return a.ForceRefreshToken()
+}
+
+// 有効期限に関わらずトークンをリフレッシュする
+func (a *Auth) ForceRefreshToken() error{
     if a.token == nil {
         return errors.New("[HealthPlanet]Token is not initialized")
     }
@@ -239,6 +322,7 @@ func (a *Auth) RefreshToken() error{
     if err != nil {
         return err
     }
+    a.token.Create_date = time.Now().Unix()
     fmt.Println("Success to refresh token")
 
     err = a.DumpToken()
@@ -282,25 +366,34 @@ func (a *Auth) InitToken() error{
     return nil
 }
 
+// HealthPlanetには失効させるAPIが無いので、ログアウトはトークンファイルを消すだけになる
+// アプリの連携はHealthPlanetの設定画面から解除する
+func (a *Auth) RemoveToken(archive bool) (string, error) {
+    return measurement.RemoveFile(a.dump_filepath, archive)
+}
+
 
 func NewClient(url string, auth *Auth, logger *slog.Logger, timezone *time.Location) *Client{
     return &Client{url: url, auth: auth, Logger: logger, Timezone: timezone}
 }
 
-func (c *Client) GetInnerscanData() (InnerscanDataMap, error){
+// /status/*.json は全て同じ形式のレスポンスを返す
+// from, toはHealthPlanetのタイムゾーンで指定する(toがゼロ値の場合は現在時刻まで)
+func (c *Client) get_status(path string, tags []string, date_type DateType, from time.Time, to time.Time) (*InnerscanResponse, error){
     u, err := url.Parse(c.url)
     if err != nil {
         return nil, err
     }
-    
-    current_date := time.Now().Add(-24 * 7 * time.Hour)
-    current_date_str := current_date.Format("20060102150405")
 
-    u.Path = "/status/innerscan.json"
+    u.Path = path
     q := u.Query()
     q.Set("access_token", c.auth.token.AccessToken)
-    q.Set("from", current_date_str)
-    q.Set("tag", "6021,6022") // 6021: Weight, 6022: Body Fat
+    q.Set("date", fmt.Sprintf("%d", date_type))
+    q.Set("from", from.In(c.Timezone).Format("20060102150405"))
+    if !to.IsZero() {
+        q.Set("to", to.In(c.Timezone).Format("20060102150405"))
+    }
+    q.Set("tag", strings.Join(tags, ","))
 
     u.RawQuery = q.Encode()
 
@@ -315,8 +408,9 @@ func (c *Client) GetInnerscanData() (InnerscanDataMap, error){
     if err != nil {
         return nil, err
     }
+    defer resp.Body.Close()
     if resp.StatusCode != 200 {
-        return nil, errors.New("[HealthPlanet]Failed to get innerscan data")
+        return nil, errors.New(fmt.Sprintf("[HealthPlanet]Failed to get %s (%d)", path, resp.StatusCode))
     }
 
     body, _ := ioutil.ReadAll(resp.Body)
@@ -327,35 +421,111 @@ func (c *Client) GetInnerscanData() (InnerscanDataMap, error){
         return nil, err
     }
 
-    return(resp_data.GetInnerscanDataMap(c.Timezone))
+    return &resp_data, nil
+}
+
+// 直近7日間に登録されたデータを返す
+func (c *Client) GetInnerscanData() (InnerscanDataMap, error){
+    from := time.Now().Add(-24 * 7 * time.Hour)
+    data, _, err := c.GetInnerscanDataRange(DateTypeRegistration, from, time.Time{})
+    return data, err
+}
+
+// from〜toのデータを返す(toがゼロ値の場合は現在時刻まで)
+// 体重計からのアップロードが遅れた場合でも取りこぼさないように、同期ではDateTypeRegistrationを使う
+// 測定データと一緒に利用者の情報も返す
+// APIは3ヶ月より長い期間を指定できないので、MaxRangeごとに分割して取得する
+func (c *Client) GetInnerscanDataRange(date_type DateType, from time.Time, to time.Time) (InnerscanDataMap, *Profile, error){
+    if to.IsZero() {
+        to = time.Now()
+    }
+
+    ret := make(InnerscanDataMap)
+    var profile *Profile
+    for start := from; !start.After(to); {
+        end := start.Add(MaxRange)
+        if end.After(to) {
+            end = to
+        }
+        resp_data, err := c.get_status("/status/innerscan.json", InnerscanTags, date_type, start, end)
+        if err != nil {
+            return nil, nil, err
+        }
+
+        profile, err = resp_data.GetProfile()
+        if err != nil {
+            return nil, nil, err
+        }
+        data, err := resp_data.GetInnerscanDataMap(c.Timezone)
+        if err != nil {
+            return nil, nil, err
+        }
+        for key, d := range data {
+            ret[key] = d
+        }
+        // from, toは秒単位で両端を含むので、次の区間は1秒後から始める
+        start = end.Truncate(time.Second).Add(time.Second)
+    }
+    return ret, profile, nil
+}
+
+// 未登録の項目はゼロ値のままにする
+func (resp *InnerscanResponse) GetProfile() (*Profile, error) {
+    p := &Profile{Sex: resp.Sex}
+    if resp.BirthDate != "" {
+        d, err := time.Parse("20060102", resp.BirthDate)
+        if err != nil {
+            return nil, err
+        }
+        p.BirthDate = d
+    }
+    if resp.Height != "" {
+        h, err := strconv.ParseFloat(resp.Height, 64)
+        if err != nil {
+            return nil, err
+        }
+        p.Height = h
+    }
+    return p, nil
 }
 
 func (resp *InnerscanResponse) GetInnerscanDataMap(timezone *time.Location) (InnerscanDataMap, error) {
     ret := make(InnerscanDataMap)
     
     for _, d := range resp.Data {
-        if _, ok := ret[d.Date]; !ok {
-            ret[d.Date] = &InnerscanData{}
+        key := innerscan_key(d.Date, d.Model)
+        if _, ok := ret[key]; !ok {
+            ret[key] = &InnerscanData{Model: d.Model}
         }
         date, err := time.ParseInLocation("200601021504", d.Date, timezone)
         if err != nil {
             return nil, err
         }
-        ret[d.Date].Date = date
-
-        if d.Tag == "6021" {
-            value, err := strconv.ParseFloat(d.KeyData, 64)
-            if err != nil {
-                return nil, err
-            }
-            ret[d.Date].Weight = value
+        ret[key].Date = date
+
+        value, err := strconv.ParseFloat(d.KeyData, 64)
+        if err != nil {
+            return nil, err
         }
-        if d.Tag == "6022" {
-            value, err := strconv.ParseFloat(d.KeyData, 64)
-            if err != nil {
-                return nil, err
-            }
-            ret[d.Date].BodyFat = value
+        switch d.Tag {
+        case TagWeight:
+            ret[key].Weight = value
+        case TagBodyFat:
+            ret[key].BodyFat = value
+        case TagMuscleMass:
+            ret[key].MuscleMass = value
+        case TagMuscleScore:
+            ret[key].MuscleScore = value
+        case TagVisceralFatLevel2:
+            ret[key].VisceralFatLevel2 = value
+        case TagVisceralFatLevel:
+            ret[key].VisceralFatLevel = value
+        case TagBasalMetabolicRate:
+            ret[key].BasalMetabolicRate = value
+        case TagBodyAge:
+            ret[key].BodyAge = value
+        case TagBoneMass:
+            ret[key].BoneMass = value
         }
     }
 
@@ -0,0 +1,67 @@
+package health_planet
+
+import (
+    "testing"
+    "time"
+)
+
+// /status/innerscan.json のレスポンス
+// 同じ日時に2台の体組成計で測定したデータを含む
+const innerscan_fixture = `{
+    "birth_date": "19800101",
+    "height": "170.5",
+    "sex": "female",
+    "data": [
+        {"date": "202401020700", "keydata": "60.50", "model": "RD-907", "tag": "6021"},
+        {"date": "202401020700", "keydata": "22.1", "model": "RD-907", "tag": "6022"},
+        {"date": "202401020700", "keydata": "8.5", "model": "RD-907", "tag": "6025"},
+        {"date": "202401020700", "keydata": "61.20", "model": "BC-768", "tag": "6021"},
+        {"date": "202401020700", "keydata": "24.0", "model": "BC-768", "tag": "6022"},
+        {"date": "202401020700", "keydata": "9", "model": "BC-768", "tag": "6026"},
+        {"date": "202401030705", "keydata": "60.30", "model": "RD-907", "tag": "6021"}
+    ]
+}`
+
+func TestGetInnerscanDataMap(t *testing.T) {
+    loc := time.FixedZone("JST", 9 * 60 * 60)
+    data, err := parse_fixture(t, innerscan_fixture).GetInnerscanDataMap(loc)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // 日時と機種ごとに1件にまとめ、同じ日時でも機種が違えば別の測定にする
+    want := map[string]InnerscanData{
+        "202401020700/RD-907": {Date: time.Date(2024, 1, 2, 7, 0, 0, 0, loc), Weight: 60.5, BodyFat: 22.1, VisceralFatLevel2: 8.5, Model: "RD-907"},
+        "202401020700/BC-768": {Date: time.Date(2024, 1, 2, 7, 0, 0, 0, loc), Weight: 61.2, BodyFat: 24.0, VisceralFatLevel: 9, Model: "BC-768"},
+        "202401030705/RD-907": {Date: time.Date(2024, 1, 3, 7, 5, 0, 0, loc), Weight: 60.3, Model: "RD-907"},
+    }
+    if len(data) != len(want) {
+        t.Fatalf("got %d data, want %d: %v", len(data), len(want), data)
+    }
+    for key, w := range want {
+        d, ok := data[key]
+        if !ok {
+            t.Errorf("%s is missing", key)
+            continue
+        }
+        if !d.Date.Equal(w.Date) || d.Weight != w.Weight || d.BodyFat != w.BodyFat || d.VisceralFatLevel2 != w.VisceralFatLevel2 || d.VisceralFatLevel != w.VisceralFatLevel || d.Model != w.Model {
+            t.Errorf("%s = %s, want %s", key, d, &w)
+        }
+    }
+}
+
+func TestGetProfile(t *testing.T) {
+    p, err := parse_fixture(t, innerscan_fixture).GetProfile()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if p.BirthDate != time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC) || p.Height != 170.5 || p.Sex != "female" {
+        t.Errorf("profile = %s", p)
+    }
+
+    // 未登録の項目はゼロ値のまま
+    p, err = parse_fixture(t, `{"data": []}`).GetProfile()
+    if err != nil || !p.BirthDate.IsZero() || p.Height != 0 || p.BMI(60) != 0 {
+        t.Errorf("profile = %s, err = %v", p, err)
+    }
+}
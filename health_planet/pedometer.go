@@ -0,0 +1,82 @@
+package health_planet
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+    "strconv"
+    "time"
+)
+
+const (
+    TagSteps = "6331" // 歩数 (歩)
+    TagDistance = "6332" // 歩行距離 (m)
+    TagCalories = "6335" // 消費カロリー (kcal)
+)
+
+// 1日分の歩数計データ
+type PedometerData struct {
+    Date time.Time
+    Steps float64
+    Distance float64
+    Calories float64
+    Model string
+}
+
+func (d *PedometerData) String() string {
+    return fmt.Sprintf("(%s)Steps: %.0f, Distance: %.0fm, Calories: %.0fkcal", d.Date.Format("2006-01-02"), d.Steps, d.Distance, d.Calories)
+}
+
+// 測定日がfrom〜toの歩数計データを日付順に返す
+func (c *Client) GetPedometerData(from time.Time, to time.Time) ([]*PedometerData, error) {
+    resp_data, err := c.get_status("/status/pedometer.json", []string{TagSteps, TagDistance, TagCalories}, DateTypeMeasurement, from, to)
+    if err != nil {
+        return nil, err
+    }
+
+    return resp_data.GetPedometerData(c.Timezone)
+}
+
+func (resp *InnerscanResponse) GetPedometerData(timezone *time.Location) ([]*PedometerData, error) {
+    m := make(map[string]*PedometerData)
+
+    for _, d := range resp.Data {
+        if len(d.Date) < 8 {
+            return nil, errors.New(fmt.Sprintf("[HealthPlanet]Invalid date: %s", d.Date))
+        }
+        day := d.Date[:8]
+        if _, ok := m[day]; !ok {
+            date, err := time.ParseInLocation("20060102", day, timezone)
+            if err != nil {
+                return nil, err
+            }
+            m[day] = &PedometerData{Date: date, Model: d.Model}
+        }
+
+        // 測定されなかった項目は値が空になるので0のままにする
+        if d.KeyData == "" {
+            continue
+        }
+        value, err := strconv.ParseFloat(d.KeyData, 64)
+        if err != nil {
+            return nil, err
+        }
+        // 同じ日に複数回記録された場合は累計値として最大のものを使う
+        switch d.Tag {
+        case TagSteps:
+            m[day].Steps = max(m[day].Steps, value)
+        case TagDistance:
+            m[day].Distance = max(m[day].Distance, value)
+        case TagCalories:
+            m[day].Calories = max(m[day].Calories, value)
+        }
+    }
+
+    var ret []*PedometerData
+    for _, d := range m {
+        ret = append(ret, d)
+    }
+    sort.Slice(ret, func(i, j int) bool { return ret[i].Date.Before(ret[j].Date) })
+
+    return ret, nil
+}
@@ -0,0 +1,67 @@
+package health_planet
+
+import (
+    "testing"
+    "time"
+)
+
+// /status/pedometer.json のレスポンス
+// 1日に複数回アップロードされた累計値と、消費カロリーが空の日を含む
+const pedometer_fixture = `{
+    "birth_date": "19800101",
+    "height": "170",
+    "sex": "male",
+    "data": [
+        {"date": "202401021200", "keydata": "4000", "model": "FB-740", "tag": "6331"},
+        {"date": "202401021200", "keydata": "2800", "model": "FB-740", "tag": "6332"},
+        {"date": "202401021200", "keydata": "120", "model": "FB-740", "tag": "6335"},
+        {"date": "202401022300", "keydata": "9500", "model": "FB-740", "tag": "6331"},
+        {"date": "202401022300", "keydata": "6650", "model": "FB-740", "tag": "6332"},
+        {"date": "202401022300", "keydata": "310", "model": "FB-740", "tag": "6335"},
+        {"date": "202401010000", "keydata": "8000", "model": "FB-740", "tag": "6331"},
+        {"date": "202401010000", "keydata": "5600", "model": "FB-740", "tag": "6332"},
+        {"date": "202401010000", "keydata": "", "model": "FB-740", "tag": "6335"}
+    ]
+}`
+
+func TestGetPedometerData(t *testing.T) {
+    loc := time.FixedZone("JST", 9 * 60 * 60)
+    data, err := parse_fixture(t, pedometer_fixture).GetPedometerData(loc)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // 1日1件にまとめて日付順に並べる
+    want := []PedometerData{
+        {Date: time.Date(2024, 1, 1, 0, 0, 0, 0, loc), Steps: 8000, Distance: 5600, Calories: 0, Model: "FB-740"},
+        {Date: time.Date(2024, 1, 2, 0, 0, 0, 0, loc), Steps: 9500, Distance: 6650, Calories: 310, Model: "FB-740"},
+    }
+    if len(data) != len(want) {
+        t.Fatalf("got %d data, want %d", len(data), len(want))
+    }
+    for i, w := range want {
+        d := data[i]
+        if !d.Date.Equal(w.Date) || d.Date.Location() != loc || d.Steps != w.Steps || d.Distance != w.Distance || d.Calories != w.Calories || d.Model != w.Model {
+            t.Errorf("data[%d] = %+v, want %+v", i, *d, w)
+        }
+    }
+}
+
+func TestGetPedometerDataInvalid(t *testing.T) {
+    tests := []struct {
+        name string
+        fixture string
+    }{
+        {"short date", `{"data": [{"date": "2024010", "keydata": "100", "model": "", "tag": "6331"}]}`},
+        {"date", `{"data": [{"date": "2024-01-02", "keydata": "100", "model": "", "tag": "6331"}]}`},
+        {"value", `{"data": [{"date": "202401020000", "keydata": "many", "model": "", "tag": "6331"}]}`},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := parse_fixture(t, tt.fixture).GetPedometerData(time.UTC)
+            if err == nil {
+                t.Errorf("expected error")
+            }
+        })
+    }
+}
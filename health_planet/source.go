@@ -0,0 +1,67 @@
+package health_planet
+
+import (
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+// HealthPlanetのAPIは3ヶ月より長い期間を指定できないので、1回の取得をこの期間までにする
+const MaxRange = 90 * 24 * time.Hour
+
+func (c *Client) Name() string {
+    return "health_planet"
+}
+
+func (c *Client) Location() *time.Location {
+    return c.Timezone
+}
+
+// 登録日時がfrom〜toの体組成データを返す (measurement.Source)
+// 3ヶ月より長い期間は分割して取得する
+// 取得した利用者の情報はc.Profileに保存し、BMIの計算に使う
+func (c *Client) Measurements(from time.Time, to time.Time) ([]measurement.BodyMeasurement, error) {
+    data, profile, err := c.GetInnerscanDataRange(DateTypeRegistration, from, to)
+    if err != nil {
+        return nil, err
+    }
+    c.Profile = profile
+
+    var ret []measurement.BodyMeasurement
+    for _, d := range data {
+        ret = append(ret, d.ToBodyMeasurement(profile))
+    }
+    measurement.SortByDate(ret)
+
+    return ret, nil
+}
+
+func (d *InnerscanData) ToBodyMeasurement(profile *Profile) measurement.BodyMeasurement {
+    // 内臓脂肪レベルは小数点有りの方を優先する
+    visceral_fat := d.VisceralFatLevel2
+    if visceral_fat == 0 {
+        visceral_fat = d.VisceralFatLevel
+    }
+
+    return measurement.BodyMeasurement{
+        Date: d.Date,
+        Weight: d.Weight,
+        BodyFat: d.BodyFat,
+        BMI: profile.BMI(d.Weight),
+        MuscleMass: d.MuscleMass,
+        MuscleScore: d.MuscleScore,
+        VisceralFatLevel: visceral_fat,
+        BasalMetabolicRate: d.BasalMetabolicRate,
+        BodyAge: d.BodyAge,
+        BoneMass: d.BoneMass,
+        Model: d.Model,
+        Source: "HealthPlanet",
+    }
+}
+
+// 身長(cm)が分からない場合は0を返す
+func (c *Client) Height() float64 {
+    if c.Profile == nil {
+        return 0
+    }
+    return c.Profile.Height
+}
@@ -0,0 +1,124 @@
+package health_planet
+
+import (
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+type status_query struct {
+    from string
+    to string
+}
+
+// 指定された期間の初日の7:00の体重を返すHealthPlanet APIのスタブ
+func new_innerscan_server(t *testing.T, queries *[]status_query) *httptest.Server {
+    t.Helper()
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+        *queries = append(*queries, status_query{q.Get("from"), q.Get("to")})
+        if q.Get("access_token") != "access" {
+            w.WriteHeader(401)
+            return
+        }
+        date := q.Get("from")[:8] + "0700"
+        fmt.Fprintf(w, `{"height": "170", "data": [{"date": "%s", "keydata": "60.5", "model": "RD-907", "tag": "6021"}]}`, date)
+    }))
+    t.Cleanup(srv.Close)
+    return srv
+}
+
+func new_test_client(url string, loc *time.Location) *Client {
+    logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+    auth := NewAuth(url, "id", "secret", "", logger)
+    auth.token = &Token{AccessToken: "access"}
+    return NewClient(url, auth, logger, loc)
+}
+
+func TestMeasurementsRange(t *testing.T) {
+    loc := time.FixedZone("JST", 9 * 60 * 60)
+    tests := []struct {
+        name string
+        from time.Time
+        to time.Time
+        queries []status_query
+    }{
+        {
+            name: "within 3 months",
+            from: time.Date(2024, 1, 1, 0, 0, 0, 0, loc),
+            to: time.Date(2024, 3, 1, 12, 0, 0, 0, loc),
+            queries: []status_query{{"20240101000000", "20240301120000"}},
+        },
+        {
+            name: "exactly 90 days",
+            from: time.Date(2024, 1, 1, 0, 0, 0, 0, loc),
+            to: time.Date(2024, 3, 31, 0, 0, 0, 0, loc),
+            queries: []status_query{{"20240101000000", "20240331000000"}},
+        },
+        {
+            // 区間の境界の時刻を2回取得しないように、次の区間は1秒後から始める
+            name: "longer than 3 months",
+            from: time.Date(2024, 1, 1, 0, 0, 0, 0, loc),
+            to: time.Date(2024, 7, 1, 0, 0, 0, 0, loc),
+            queries: []status_query{
+                {"20240101000000", "20240331000000"},
+                {"20240331000001", "20240629000001"},
+                {"20240629000002", "20240701000000"},
+            },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var queries []status_query
+            srv := new_innerscan_server(t, &queries)
+            c := new_test_client(srv.URL, loc)
+
+            data, err := c.Measurements(tt.from, tt.to)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(queries) != len(tt.queries) {
+                t.Fatalf("queries = %v, want %v", queries, tt.queries)
+            }
+            for i, q := range tt.queries {
+                if queries[i] != q {
+                    t.Errorf("queries[%d] = %v, want %v", i, queries[i], q)
+                }
+            }
+            // 区間ごとの結果をまとめて日時順に返す
+            if len(data) != len(tt.queries) {
+                t.Fatalf("got %d measurements, want %d", len(data), len(tt.queries))
+            }
+            for i := 1; i < len(data); i++ {
+                if !data[i - 1].Date.Before(data[i].Date) {
+                    t.Errorf("measurements are not sorted: %s, %s", data[i - 1].Date, data[i].Date)
+                }
+            }
+            if c.Height() != 170 || data[0].Weight != 60.5 || data[0].BMI != 20.9 {
+                t.Errorf("height = %.1f, measurements[0] = %+v", c.Height(), data[0])
+            }
+        })
+    }
+}
+
+func TestMeasurementsError(t *testing.T) {
+    loc := time.FixedZone("JST", 9 * 60 * 60)
+    var queries []status_query
+    srv := new_innerscan_server(t, &queries)
+    c := new_test_client(srv.URL, loc)
+    c.auth.token.AccessToken = "expired"
+
+    // 途中の区間で失敗した場合は全体を失敗にする
+    _, err := c.Measurements(time.Date(2024, 1, 1, 0, 0, 0, 0, loc), time.Date(2024, 7, 1, 0, 0, 0, 0, loc))
+    if err == nil {
+        t.Errorf("expected error")
+    }
+    if len(queries) != 1 {
+        t.Errorf("queries = %v", queries)
+    }
+}
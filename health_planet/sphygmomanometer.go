@@ -0,0 +1,75 @@
+package health_planet
+
+import (
+    "fmt"
+    "sort"
+    "strconv"
+    "time"
+)
+
+const (
+    TagSystolic = "622E" // 最高血圧 (mmHg)
+    TagDiastolic = "622F" // 最低血圧 (mmHg)
+    TagPulse = "6230" // 脈拍 (bpm)
+)
+
+type BloodPressureData struct {
+    Date time.Time
+    Systolic float64
+    Diastolic float64
+    Pulse float64
+    Model string
+}
+
+func (d *BloodPressureData) String() string {
+    return fmt.Sprintf("(%s)Systolic: %.0f, Diastolic: %.0f, Pulse: %.0f", d.Date, d.Systolic, d.Diastolic, d.Pulse)
+}
+
+// 測定日時がfrom〜toの血圧データを日時順に返す
+func (c *Client) GetBloodPressureData(from time.Time, to time.Time) ([]*BloodPressureData, error) {
+    resp_data, err := c.get_status("/status/sphygmomanometer.json", []string{TagSystolic, TagDiastolic, TagPulse}, DateTypeMeasurement, from, to)
+    if err != nil {
+        return nil, err
+    }
+
+    return resp_data.GetBloodPressureData(c.Timezone)
+}
+
+func (resp *InnerscanResponse) GetBloodPressureData(timezone *time.Location) ([]*BloodPressureData, error) {
+    m := make(map[string]*BloodPressureData)
+
+    for _, d := range resp.Data {
+        if _, ok := m[d.Date]; !ok {
+            date, err := time.ParseInLocation("200601021504", d.Date, timezone)
+            if err != nil {
+                return nil, err
+            }
+            m[d.Date] = &BloodPressureData{Date: date, Model: d.Model}
+        }
+
+        // 測定されなかった項目は値が空になるので0のままにする
+        if d.KeyData == "" {
+            continue
+        }
+        value, err := strconv.ParseFloat(d.KeyData, 64)
+        if err != nil {
+            return nil, err
+        }
+        switch d.Tag {
+        case TagSystolic:
+            m[d.Date].Systolic = value
+        case TagDiastolic:
+            m[d.Date].Diastolic = value
+        case TagPulse:
+            m[d.Date].Pulse = value
+        }
+    }
+
+    var ret []*BloodPressureData
+    for _, d := range m {
+        ret = append(ret, d)
+    }
+    sort.Slice(ret, func(i, j int) bool { return ret[i].Date.Before(ret[j].Date) })
+
+    return ret, nil
+}
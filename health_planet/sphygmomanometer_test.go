@@ -0,0 +1,84 @@
+package health_planet
+
+import (
+    "encoding/json"
+    "testing"
+    "time"
+)
+
+// /status/sphygmomanometer.json のレスポンス
+// 2件目の測定は脈拍が空、3件目は最低血圧が無い
+const sphygmomanometer_fixture = `{
+    "birth_date": "19800101",
+    "height": "170",
+    "sex": "male",
+    "data": [
+        {"date": "202401020730", "keydata": "82", "model": "HEM-7600T", "tag": "622F"},
+        {"date": "202401020730", "keydata": "125", "model": "HEM-7600T", "tag": "622E"},
+        {"date": "202401020730", "keydata": "64", "model": "HEM-7600T", "tag": "6230"},
+        {"date": "202401012215", "keydata": "118", "model": "HEM-7600T", "tag": "622E"},
+        {"date": "202401012215", "keydata": "76", "model": "HEM-7600T", "tag": "622F"},
+        {"date": "202401012215", "keydata": "", "model": "HEM-7600T", "tag": "6230"},
+        {"date": "202401030700", "keydata": "130", "model": "HEM-7600T", "tag": "622E"},
+        {"date": "202401030700", "keydata": "70", "model": "HEM-7600T", "tag": "6230"}
+    ]
+}`
+
+func parse_fixture(t *testing.T, fixture string) *InnerscanResponse {
+    t.Helper()
+    resp := &InnerscanResponse{}
+    err := json.Unmarshal([]byte(fixture), resp)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return resp
+}
+
+func TestGetBloodPressureData(t *testing.T) {
+    loc := time.FixedZone("JST", 9 * 60 * 60)
+    data, err := parse_fixture(t, sphygmomanometer_fixture).GetBloodPressureData(loc)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // 日時順に並べる
+    want := []BloodPressureData{
+        {Date: time.Date(2024, 1, 1, 22, 15, 0, 0, loc), Systolic: 118, Diastolic: 76, Pulse: 0, Model: "HEM-7600T"},
+        {Date: time.Date(2024, 1, 2, 7, 30, 0, 0, loc), Systolic: 125, Diastolic: 82, Pulse: 64, Model: "HEM-7600T"},
+        {Date: time.Date(2024, 1, 3, 7, 0, 0, 0, loc), Systolic: 130, Diastolic: 0, Pulse: 70, Model: "HEM-7600T"},
+    }
+    if len(data) != len(want) {
+        t.Fatalf("got %d data, want %d", len(data), len(want))
+    }
+    for i, w := range want {
+        d := data[i]
+        if !d.Date.Equal(w.Date) || d.Date.Location() != loc || d.Systolic != w.Systolic || d.Diastolic != w.Diastolic || d.Pulse != w.Pulse || d.Model != w.Model {
+            t.Errorf("data[%d] = %+v, want %+v", i, *d, w)
+        }
+    }
+}
+
+func TestGetBloodPressureDataInvalid(t *testing.T) {
+    tests := []struct {
+        name string
+        fixture string
+    }{
+        {"date", `{"data": [{"date": "2024-01-02 07:30", "keydata": "125", "model": "", "tag": "622E"}]}`},
+        {"value", `{"data": [{"date": "202401020730", "keydata": "high", "model": "", "tag": "622E"}]}`},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := parse_fixture(t, tt.fixture).GetBloodPressureData(time.UTC)
+            if err == nil {
+                t.Errorf("expected error")
+            }
+        })
+    }
+}
+
+func TestGetBloodPressureDataEmpty(t *testing.T) {
+    data, err := parse_fixture(t, `{"data": []}`).GetBloodPressureData(time.UTC)
+    if err != nil || len(data) != 0 {
+        t.Errorf("data = %v, err = %v", data, err)
+    }
+}
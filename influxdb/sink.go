@@ -0,0 +1,360 @@
+package influxdb
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+    "log/slog"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+const default_measurement = "body"
+
+// InfluxDBへの書き込み設定
+// fileを指定した場合はHTTPで送らずにline protocolをファイルに追記する
+type Config struct {
+    Url string `json:"url"`
+    // InfluxDB 1.x はdatabase、2.x はbucket(1.x互換APIのdatabaseとしても使う)
+    Database string `json:"database"`
+    // 2.x の場合に指定する
+    Org string `json:"org"`
+    Token string `json:"token"`
+    // 1.x で認証が有効な場合に指定する
+    Username string `json:"username"`
+    Password string `json:"password"`
+
+    Measurement string `json:"measurement"`
+    // 全てのポイントに付けるタグ (例: {"user": "alice"})
+    Tags map[string]string `json:"tags"`
+
+    File string `json:"file"`
+}
+
+type Sink struct {
+    conf Config
+    Logger *slog.Logger
+}
+
+func NewSink(conf Config, logger *slog.Logger) *Sink {
+    if conf.Measurement == "" {
+        conf.Measurement = default_measurement
+    }
+    return &Sink{conf: conf, Logger: logger}
+}
+
+func (s *Sink) Name() string {
+    return "influxdb"
+}
+
+// 値が無い(0の)フィールドは書き込まない
+func fields(m measurement.BodyMeasurement) map[string]float64 {
+    f := map[string]float64{
+        "weight": m.Weight,
+        "body_fat": m.BodyFat,
+        "bmi": m.BMI,
+        "muscle_mass": m.MuscleMass,
+        "muscle_score": m.MuscleScore,
+        "visceral_fat_level": m.VisceralFatLevel,
+        "basal_metabolic_rate": m.BasalMetabolicRate,
+        "body_age": m.BodyAge,
+        "bone_mass": m.BoneMass,
+    }
+    for k, v := range f {
+        if v == 0 {
+            delete(f, k)
+        }
+    }
+    return f
+}
+
+var measurement_escaper = strings.NewReplacer(",", "\\,", " ", "\\ ")
+var tag_escaper = strings.NewReplacer(",", "\\,", " ", "\\ ", "=", "\\=")
+
+// 1件の測定データをline protocolの1行にする (タイムスタンプは秒)
+func (s *Sink) Line(m measurement.BodyMeasurement) string {
+    tags := make(map[string]string)
+    for k, v := range s.conf.Tags {
+        tags[k] = v
+    }
+    if m.Model != "" {
+        tags["model"] = m.Model
+    }
+    if m.Source != "" {
+        tags["source"] = m.Source
+    }
+
+    var b strings.Builder
+    b.WriteString(measurement_escaper.Replace(s.conf.Measurement))
+    for _, k := range sorted_keys(tags) {
+        fmt.Fprintf(&b, ",%s=%s", tag_escaper.Replace(k), tag_escaper.Replace(tags[k]))
+    }
+
+    f := fields(m)
+    for i, k := range sorted_keys(f) {
+        sep := ","
+        if i == 0 {
+            sep = " "
+        }
+        fmt.Fprintf(&b, "%s%s=%s", sep, tag_escaper.Replace(k), strconv.FormatFloat(f[k], 'f', -1, 64))
+    }
+    fmt.Fprintf(&b, " %d", m.Date.Unix())
+
+    return b.String()
+}
+
+func sorted_keys[V any](m map[string]V) []string {
+    var keys []string
+    for k := range m {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+func (s *Sink) Write(m measurement.BodyMeasurement) error {
+    line := s.Line(m)
+    s.Logger.Debug(fmt.Sprintf("[influxdb]Write: %s", line))
+
+    if s.conf.File != "" {
+        f, err := os.OpenFile(s.conf.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+        if err != nil {
+            return err
+        }
+        defer f.Close()
+        _, err = fmt.Fprintln(f, line)
+        return err
+    }
+
+    u, err := url.Parse(s.conf.Url)
+    if err != nil {
+        return err
+    }
+    q := u.Query()
+    if s.conf.Org != "" {
+        u.Path = "/api/v2/write"
+        q.Set("org", s.conf.Org)
+        q.Set("bucket", s.conf.Database)
+    } else {
+        u.Path = "/write"
+        q.Set("db", s.conf.Database)
+    }
+    q.Set("precision", "s")
+    u.RawQuery = q.Encode()
+
+    req, err := http.NewRequest("POST", u.String(), strings.NewReader(line + "\n"))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+    s.set_auth(req)
+
+    client := &http.Client{}
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != 204 && resp.StatusCode != 200 {
+        body, _ := ioutil.ReadAll(resp.Body)
+        return errors.New(fmt.Sprintf("[influxdb]Failed to write: (%d) %s", resp.StatusCode, body))
+    }
+    return nil
+}
+
+func (s *Sink) set_auth(req *http.Request) {
+    if s.conf.Token != "" {
+        req.Header.Set("Authorization", "Token " + s.conf.Token)
+    } else if s.conf.Username != "" {
+        req.SetBasicAuth(s.conf.Username, s.conf.Password)
+    }
+}
+
+// dateと同じ日(dateのタイムゾーン)に書き込まれているポイントを返す (measurement.Sink)
+func (s *Sink) Existing(date time.Time) ([]measurement.BodyMeasurement, error) {
+    start := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+    end := start.AddDate(0, 0, 1)
+
+    if s.conf.File != "" {
+        return s.existing_file(start, end)
+    }
+    return s.existing_http(start, end)
+}
+
+func (s *Sink) existing_file(start time.Time, end time.Time) ([]measurement.BodyMeasurement, error) {
+    f, err := os.Open(s.conf.File)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    var ret []measurement.BodyMeasurement
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        m, err := parse_line(line, start.Location())
+        if err != nil {
+            return nil, errors.New(fmt.Sprintf("[influxdb]Invalid line in %s: %s", s.conf.File, line))
+        }
+        if m.Date.Before(start) || !m.Date.Before(end) {
+            continue
+        }
+        ret = append(ret, m)
+    }
+    return ret, scanner.Err()
+}
+
+// sをエスケープされていないsepで分割する
+func split_unescaped(s string, sep byte) []string {
+    var ret []string
+    start := 0
+    for i := 0; i < len(s); i++ {
+        if s[i] == '\\' {
+            i++
+            continue
+        }
+        if s[i] == sep {
+            ret = append(ret, s[start:i])
+            start = i + 1
+        }
+    }
+    return append(ret, s[start:])
+}
+
+// Lineで書き込んだ行から、HTTPの問い合わせと同じく日時・体重・体脂肪率を読む
+// "<measurement>[,<tag>=<value>...] <field>=<value>[,...] <timestamp>"
+func parse_line(line string, loc *time.Location) (measurement.BodyMeasurement, error) {
+    m := measurement.BodyMeasurement{}
+    parts := split_unescaped(line, ' ')
+    if len(parts) != 3 {
+        return m, errors.New("must be \"<measurement> <fields> <timestamp>\"")
+    }
+
+    ts, err := strconv.ParseInt(parts[2], 10, 64)
+    if err != nil {
+        return m, err
+    }
+    m.Date = time.Unix(ts, 0).In(loc)
+
+    for _, f := range split_unescaped(parts[1], ',') {
+        kv := strings.SplitN(f, "=", 2)
+        if len(kv) != 2 {
+            return m, errors.New(fmt.Sprintf("invalid field: %s", f))
+        }
+        switch kv[0] {
+        case "weight":
+            m.Weight, err = strconv.ParseFloat(kv[1], 64)
+        case "body_fat":
+            m.BodyFat, err = strconv.ParseFloat(kv[1], 64)
+        }
+        if err != nil {
+            return m, err
+        }
+    }
+    return m, nil
+}
+
+type queryResponse struct {
+    Results []struct {
+        Error string `json:"error"`
+        Series []struct {
+            Columns []string `json:"columns"`
+            Values [][]json.Number `json:"values"`
+        } `json:"series"`
+    } `json:"results"`
+}
+
+// InfluxQLで問い合わせる (2.xでは1.x互換APIを使う)
+func (s *Sink) existing_http(start time.Time, end time.Time) ([]measurement.BodyMeasurement, error) {
+    u, err := url.Parse(s.conf.Url)
+    if err != nil {
+        return nil, err
+    }
+
+    where := []string{
+        fmt.Sprintf("time >= %ds", start.Unix()),
+        fmt.Sprintf("time < %ds", end.Unix()),
+    }
+    for _, k := range sorted_keys(s.conf.Tags) {
+        where = append(where, fmt.Sprintf("\"%s\" = '%s'", k, strings.ReplaceAll(s.conf.Tags[k], "'", "\\'")))
+    }
+
+    u.Path = "/query"
+    q := u.Query()
+    q.Set("db", s.conf.Database)
+    q.Set("epoch", "s")
+    q.Set("q", fmt.Sprintf("SELECT \"weight\", \"body_fat\" FROM \"%s\" WHERE %s", s.conf.Measurement, strings.Join(where, " AND ")))
+    u.RawQuery = q.Encode()
+
+    s.Logger.Debug(fmt.Sprintf("[influxdb]Query: %s", u.String()))
+
+    req, err := http.NewRequest("GET", u.String(), nil)
+    if err != nil {
+        return nil, err
+    }
+    s.set_auth(req)
+
+    client := &http.Client{}
+    resp, err := client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    body, _ := ioutil.ReadAll(resp.Body)
+    if resp.StatusCode != 200 {
+        return nil, errors.New(fmt.Sprintf("[influxdb]Failed to query: (%d) %s", resp.StatusCode, body))
+    }
+
+    qr := queryResponse{}
+    err = json.Unmarshal(body, &qr)
+    if err != nil {
+        return nil, err
+    }
+
+    var ret []measurement.BodyMeasurement
+    for _, r := range qr.Results {
+        if r.Error != "" {
+            return nil, errors.New(fmt.Sprintf("[influxdb]Failed to query: %s", r.Error))
+        }
+        for _, series := range r.Series {
+            for _, v := range series.Values {
+                m := measurement.BodyMeasurement{}
+                for i, col := range series.Columns {
+                    if i >= len(v) || v[i] == "" {
+                        continue
+                    }
+                    switch col {
+                    case "time":
+                        ts, err := v[i].Int64()
+                        if err != nil {
+                            return nil, err
+                        }
+                        m.Date = time.Unix(ts, 0).In(start.Location())
+                    case "weight":
+                        m.Weight, _ = v[i].Float64()
+                    case "body_fat":
+                        m.BodyFat, _ = v[i].Float64()
+                    }
+                }
+                ret = append(ret, m)
+            }
+        }
+    }
+    return ret, nil
+}
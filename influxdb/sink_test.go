@@ -0,0 +1,259 @@
+package influxdb
+
+import (
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+var test_logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+var test_date = time.Date(2024, 1, 2, 7, 30, 0, 0, time.FixedZone("JST", 9 * 60 * 60))
+
+func TestLine(t *testing.T) {
+    tests := []struct {
+        name string
+        conf Config
+        m measurement.BodyMeasurement
+        want string
+    }{
+        {
+            name: "default measurement and zero fields",
+            conf: Config{},
+            m: measurement.BodyMeasurement{Date: test_date, Weight: 60.25, BodyFat: 20.1},
+            want: fmt.Sprintf("body body_fat=20.1,weight=60.25 %d", test_date.Unix()),
+        },
+        {
+            name: "sorted tags with model and source",
+            conf: Config{Measurement: "scale", Tags: map[string]string{"user": "alice", "home": "tokyo"}},
+            m: measurement.BodyMeasurement{Date: test_date, Weight: 60, BMI: 21.5, Model: "01000144", Source: "API"},
+            want: fmt.Sprintf("scale,home=tokyo,model=01000144,source=API,user=alice bmi=21.5,weight=60 %d", test_date.Unix()),
+        },
+        {
+            name: "escape",
+            conf: Config{Measurement: "body weight,x", Tags: map[string]string{"user name": "a=b, c"}},
+            m: measurement.BodyMeasurement{Date: test_date, Weight: 60},
+            want: fmt.Sprintf(`body\ weight\,x,user\ name=a\=b\,\ c weight=60 %d`, test_date.Unix()),
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            s := NewSink(tt.conf, test_logger)
+            if got := s.Line(tt.m); got != tt.want {
+                t.Errorf("got  %s\nwant %s", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestWrite(t *testing.T) {
+    tests := []struct {
+        name string
+        conf Config
+        path string
+        query map[string]string
+        auth func(r *http.Request) bool
+    }{
+        {
+            name: "1.x",
+            conf: Config{Database: "health"},
+            path: "/write",
+            query: map[string]string{"db": "health", "precision": "s"},
+            auth: func(r *http.Request) bool { return r.Header.Get("Authorization") == "" },
+        },
+        {
+            name: "1.x with basic auth",
+            conf: Config{Database: "health", Username: "user", Password: "pass"},
+            path: "/write",
+            query: map[string]string{"db": "health", "precision": "s"},
+            auth: func(r *http.Request) bool {
+                u, p, ok := r.BasicAuth()
+                return ok && u == "user" && p == "pass"
+            },
+        },
+        {
+            name: "2.x",
+            conf: Config{Database: "health", Org: "home", Token: "secret", Username: "ignored"},
+            path: "/api/v2/write",
+            query: map[string]string{"org": "home", "bucket": "health", "precision": "s"},
+            auth: func(r *http.Request) bool { return r.Header.Get("Authorization") == "Token secret" },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var got *http.Request
+            var body string
+            srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                b, _ := io.ReadAll(r.Body)
+                got = r
+                body = string(b)
+                w.WriteHeader(204)
+            }))
+            defer srv.Close()
+
+            tt.conf.Url = srv.URL
+            s := NewSink(tt.conf, test_logger)
+            m := measurement.BodyMeasurement{Date: test_date, Weight: 60}
+            err := s.Write(m)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got.Method != "POST" || got.URL.Path != tt.path {
+                t.Errorf("request = %s %s", got.Method, got.URL.Path)
+            }
+            for k, v := range tt.query {
+                if got.URL.Query().Get(k) != v {
+                    t.Errorf("query %s = %q, want %q", k, got.URL.Query().Get(k), v)
+                }
+            }
+            if !tt.auth(got) {
+                t.Errorf("unexpected auth: %v", got.Header)
+            }
+            if body != s.Line(m) + "\n" {
+                t.Errorf("body = %q", body)
+            }
+        })
+    }
+}
+
+func TestWriteError(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(401)
+        w.Write([]byte(`{"code":"unauthorized"}`))
+    }))
+    defer srv.Close()
+
+    s := NewSink(Config{Url: srv.URL, Database: "health"}, test_logger)
+    err := s.Write(measurement.BodyMeasurement{Date: test_date, Weight: 60})
+    if err == nil || !strings.Contains(err.Error(), "401") {
+        t.Errorf("err = %v", err)
+    }
+}
+
+func TestExistingHTTP(t *testing.T) {
+    var query *http.Request
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        query = r
+        // 体脂肪率の無いポイントはnullになる
+        fmt.Fprintf(w, `{"results": [{"series": [{"name": "body", "columns": ["time", "weight", "body_fat"], "values": [[%d, 60.25, 20.1], [%d, 61, null]]}]}]}`, test_date.Unix(), test_date.Add(time.Hour).Unix())
+    }))
+    defer srv.Close()
+
+    s := NewSink(Config{Url: srv.URL, Database: "health", Token: "secret", Tags: map[string]string{"user": "o'neil"}}, test_logger)
+    got, err := s.Existing(test_date)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    start := time.Date(2024, 1, 2, 0, 0, 0, 0, test_date.Location())
+    q := query.URL.Query()
+    want_q := fmt.Sprintf(`SELECT "weight", "body_fat" FROM "body" WHERE time >= %ds AND time < %ds AND "user" = 'o\'neil'`, start.Unix(), start.AddDate(0, 0, 1).Unix())
+    if query.URL.Path != "/query" || q.Get("db") != "health" || q.Get("epoch") != "s" || q.Get("q") != want_q {
+        t.Errorf("query = %s %v", query.URL.Path, q)
+    }
+    if query.Header.Get("Authorization") != "Token secret" {
+        t.Errorf("auth = %q", query.Header.Get("Authorization"))
+    }
+
+    if len(got) != 2 {
+        t.Fatalf("got %d points", len(got))
+    }
+    if !got[0].Date.Equal(test_date) || got[0].Date.Location() != test_date.Location() || got[0].Weight != 60.25 || got[0].BodyFat != 20.1 {
+        t.Errorf("got[0] = %s", &got[0])
+    }
+    if got[1].Weight != 61 || got[1].BodyFat != 0 {
+        t.Errorf("got[1] = %s", &got[1])
+    }
+}
+
+func TestExistingHTTPError(t *testing.T) {
+    tests := []struct {
+        name string
+        status int
+        body string
+    }{
+        {"http status", 500, `internal error`},
+        {"query error", 200, `{"results": [{"error": "database not found: health"}]}`},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                w.WriteHeader(tt.status)
+                w.Write([]byte(tt.body))
+            }))
+            defer srv.Close()
+
+            s := NewSink(Config{Url: srv.URL, Database: "health"}, test_logger)
+            _, err := s.Existing(test_date)
+            if err == nil {
+                t.Errorf("expected error")
+            }
+        })
+    }
+}
+
+func TestExistingFile(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "body.lp")
+    s := NewSink(Config{File: path, Measurement: "body weight", Tags: map[string]string{"user": "alice smith"}}, test_logger)
+
+    // ファイルが無い場合は空
+    got, err := s.Existing(test_date)
+    if err != nil || len(got) != 0 {
+        t.Fatalf("got = %v, err = %v", got, err)
+    }
+
+    dates := []time.Time{
+        test_date.AddDate(0, 0, -1),
+        test_date,
+        test_date.Add(10 * time.Hour),
+        test_date.AddDate(0, 0, 1),
+    }
+    for i, d := range dates {
+        err := s.Write(measurement.BodyMeasurement{Date: d, Weight: 60 + float64(i) / 10, BodyFat: 20.5, BMI: 21, Model: "model 1,a=b"})
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    f.WriteString("\n# comment\n")
+    f.Close()
+
+    got, err = s.Existing(test_date)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(got) != 2 || !got[0].Date.Equal(dates[1]) || !got[1].Date.Equal(dates[2]) {
+        t.Fatalf("got = %v", got)
+    }
+    // エスケープされた空白やカンマを含む行でも体重と体脂肪率を読む
+    if got[0].Weight != 60.1 || got[1].Weight != 60.2 || got[0].BodyFat != 20.5 {
+        t.Errorf("got = %v", got)
+    }
+
+    invalid := []string{
+        "body weight=60 broken",
+        "body weight=60",
+        "body weight=sixty 1704148200",
+        "body weight 1704148200",
+    }
+    for _, line := range invalid {
+        os.WriteFile(path, []byte(line + "\n"), 0644)
+        _, err = s.Existing(test_date)
+        if err == nil {
+            t.Errorf("expected error for invalid line %q", line)
+        }
+    }
+}
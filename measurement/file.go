@@ -0,0 +1,35 @@
+package measurement
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "time"
+)
+
+// vをJSONでpathに保存する
+// 書き込み途中で止まっても壊れないように、一時ファイルに書いてから置き換える
+func SaveJSON(path string, v any) error {
+    data, err := json.MarshalIndent(v, "", "  ")
+    if err != nil {
+        return err
+    }
+
+    tmp := path + ".tmp"
+    err = ioutil.WriteFile(tmp, data, 0644)
+    if err != nil {
+        return err
+    }
+    return os.Rename(tmp, path)
+}
+
+// pathのファイルを削除する
+// archiveがtrueの場合は削除せずに日時付きのファイル名(<path>.<日時>.bak)に変更し、変更後のパスを返す
+func RemoveFile(path string, archive bool) (string, error) {
+    if archive {
+        archive_path := fmt.Sprintf("%s.%s.bak", path, time.Now().Format("20060102150405"))
+        return archive_path, os.Rename(path, archive_path)
+    }
+    return "", os.Remove(path)
+}
@@ -0,0 +1,43 @@
+package measurement
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestRemoveFile(t *testing.T) {
+    for _, archive := range []bool{false, true} {
+        path := filepath.Join(t.TempDir(), "token.json")
+        err := os.WriteFile(path, []byte(`{}`), 0600)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        archive_path, err := RemoveFile(path, archive)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, err := os.Stat(path); !os.IsNotExist(err) {
+            t.Errorf("archive=%v: %s still exists", archive, path)
+        }
+        if !archive {
+            if archive_path != "" {
+                t.Errorf("archive path = %q", archive_path)
+            }
+            continue
+        }
+        if !strings.HasPrefix(archive_path, path + ".") || !strings.HasSuffix(archive_path, ".bak") {
+            t.Errorf("archive path = %q", archive_path)
+        }
+        if data, err := os.ReadFile(archive_path); err != nil || string(data) != `{}` {
+            t.Errorf("archived file = %q, err = %v", data, err)
+        }
+    }
+
+    // 無いファイルはエラーにする
+    if _, err := RemoveFile(filepath.Join(t.TempDir(), "missing.json"), false); err == nil {
+        t.Errorf("expected error")
+    }
+}
@@ -0,0 +1,103 @@
+package measurement
+
+import (
+    "encoding/json"
+    "errors"
+    "io/ioutil"
+    "os"
+    "time"
+)
+
+const (
+    StatusDelivered = "delivered"
+    StatusFailed = "failed"
+)
+
+type Delivery struct {
+    Date int64 `json:"date"`
+    Model string `json:"model,omitempty"`
+    Weight float64 `json:"weight"`
+    Status string `json:"status"`
+    Attempts int `json:"attempts"`
+    LastError string `json:"last_error,omitempty"`
+    UpdatedAt int64 `json:"updated_at"`
+}
+
+// 送信先から記録済みのデータを取得できない送信先(webhookなど)のための送信履歴
+type Ledger struct {
+    Deliveries []*Delivery `json:"deliveries"`
+
+    path string
+}
+
+// ファイルが無い場合は空の履歴を返す
+func LoadLedger(path string) (*Ledger, error) {
+    l := &Ledger{path: path}
+    data, err := ioutil.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return l, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    err = json.Unmarshal(data, l)
+    if err != nil {
+        return nil, err
+    }
+    return l, nil
+}
+
+func (l *Ledger) find(m BodyMeasurement) *Delivery {
+    for _, d := range l.Deliveries {
+        if d.Date == m.Date.Unix() && d.Model == m.Model {
+            return d
+        }
+    }
+    return nil
+}
+
+// 送信結果を成功・失敗に関わらず記録して保存する
+// 送信のエラー(err)があればそれを、無ければ保存のエラーを返すので、送信先のWriteの戻り値にそのまま使える
+func (l *Ledger) Record(m BodyMeasurement, attempts int, err error) error {
+    d := l.find(m)
+    if d == nil {
+        d = &Delivery{Date: m.Date.Unix(), Model: m.Model}
+        l.Deliveries = append(l.Deliveries, d)
+    }
+    d.Weight = m.Weight
+    d.Attempts += attempts
+    d.UpdatedAt = time.Now().Unix()
+    if err != nil {
+        d.Status = StatusFailed
+        d.LastError = err.Error()
+    } else {
+        d.Status = StatusDelivered
+        d.LastError = ""
+    }
+    save_err := l.Save()
+    if err != nil {
+        return err
+    }
+    return save_err
+}
+
+func (l *Ledger) Save() error {
+    return SaveJSON(l.path, l)
+}
+
+// dateと同じ日(dateのタイムゾーン)に送信済みのデータを返す
+// 送信に失敗したものは含めないので、次の同期で再送される
+func (l *Ledger) Existing(date time.Time) []BodyMeasurement {
+    start := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+    end := start.AddDate(0, 0, 1)
+
+    var ret []BodyMeasurement
+    for _, d := range l.Deliveries {
+        t := time.Unix(d.Date, 0).In(date.Location())
+        if d.Status != StatusDelivered || t.Before(start) || !t.Before(end) {
+            continue
+        }
+        ret = append(ret, BodyMeasurement{Date: t, Weight: d.Weight, Model: d.Model})
+    }
+    return ret
+}
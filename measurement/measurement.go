@@ -0,0 +1,80 @@
+package measurement
+
+import (
+    "fmt"
+    "sort"
+    "time"
+)
+
+// 取得元・送信先に依存しない体組成の測定データ
+// 任意項目は測定されていない場合0になる
+// JSONはwebhook・MQTTで送る形式
+type BodyMeasurement struct {
+    Date time.Time `json:"date"` // 測定日時(タイムゾーン付き)
+    Weight float64 `json:"weight"` // kg
+    BodyFat float64 `json:"body_fat,omitempty"` // %
+
+    // 任意項目
+    BMI float64 `json:"bmi,omitempty"`
+    MuscleMass float64 `json:"muscle_mass,omitempty"` // kg
+    MuscleScore float64 `json:"muscle_score,omitempty"`
+    VisceralFatLevel float64 `json:"visceral_fat_level,omitempty"`
+    BasalMetabolicRate float64 `json:"basal_metabolic_rate,omitempty"` // kcal
+    BodyAge float64 `json:"body_age,omitempty"`
+    BoneMass float64 `json:"bone_mass,omitempty"` // kg
+
+    Model string `json:"model,omitempty"` // 測定した機器
+    Source string `json:"source,omitempty"` // 取得元での記録元 (例: FitbitのAPI/Aria/Web)
+}
+
+func (m *BodyMeasurement) String() string {
+    return fmt.Sprintf("(%s)Weight: %f, BodyFat: %f, Model: %s", m.Date, m.Weight, m.BodyFat, m.Model)
+}
+
+// 測定データの取得元
+type Source interface {
+    Name() string
+    // 取得元の日付を区切るタイムゾーン (1日分の測定をまとめる場合に使う)
+    Location() *time.Location
+    // from〜toの間に取得元で利用可能になった測定データを返す
+    // (どの日時で絞り込むかは取得元による。HealthPlanetは登録日時)
+    Measurements(from time.Time, to time.Time) ([]BodyMeasurement, error)
+}
+
+// 過去のデータを取り込むための取得元 (CSVなど)
+// FullHistoryがtrueの場合、初回の同期は既定の期間ではなく全ての期間を取得する
+type HistorySource interface {
+    FullHistory() bool
+}
+
+// 測定データの送信先
+type Sink interface {
+    Name() string
+    // dateと同じ日(送信先のタイムゾーン)に記録済みの測定データを返す
+    Existing(date time.Time) ([]BodyMeasurement, error)
+    Write(m BodyMeasurement) error
+}
+
+// 期間の記録をまとめて取得できる送信先
+// (日ごとに取得するとリクエスト数の制限にかかる送信先は、比較の前にまとめて取得する)
+type RangeSink interface {
+    // from〜toの日付(送信先のタイムゾーン)に記録済みの測定データを返す
+    ExistingRange(from time.Time, to time.Time) ([]BodyMeasurement, error)
+}
+
+// 記録ごとに書き込み元(BodyMeasurement.Source)を返す送信先
+// 他のアプリ経由の記録と重複しないように、同期の重複の方針(fitbit_duplicate_policy)を適用する
+// WriteSourceはWriteで書き込んだ記録の書き込み元 (Fitbitは"API")
+type SourcedSink interface {
+    WriteSource() string
+}
+
+// 日時を現地時刻で記録する送信先のタイムゾーン
+// 実装していない送信先は測定日時のタイムゾーンで日付を区切る
+type Located interface {
+    Location() *time.Location
+}
+
+func SortByDate(ms []BodyMeasurement) {
+    sort.SliceStable(ms, func(i, j int) bool { return ms[i].Date.Before(ms[j].Date) })
+}
@@ -0,0 +1,264 @@
+package mqtt
+
+import (
+    "bufio"
+    "crypto/tls"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/url"
+    "time"
+)
+
+// MQTT 3.1.1 のパケット種別
+const (
+    packet_connect = 1
+    packet_connack = 2
+    packet_publish = 3
+    packet_puback = 4
+    packet_pubrec = 5
+    packet_pubrel = 6
+    packet_pubcomp = 7
+    packet_disconnect = 14
+)
+
+const keep_alive = 60
+
+// 測定データを送るだけの最小限のMQTT 3.1.1クライアント (publishのみ)
+type Client struct {
+    conn net.Conn
+    r *bufio.Reader
+    packet_id uint16
+    timeout time.Duration
+}
+
+type ConnectOptions struct {
+    ClientId string
+    Username string
+    Password string
+    Timeout time.Duration
+}
+
+// brokerは tcp://host:1883 または mqtts://host:8883 (ssl://, tls:// も可)
+func Connect(broker string, opts ConnectOptions) (*Client, error) {
+    u, err := url.Parse(broker)
+    if err != nil {
+        return nil, err
+    }
+
+    dialer := &net.Dialer{Timeout: opts.Timeout}
+    var conn net.Conn
+    switch u.Scheme {
+    case "tcp", "mqtt":
+        conn, err = dialer.Dial("tcp", default_port(u.Host, "1883"))
+    case "mqtts", "ssl", "tls":
+        conn, err = tls.DialWithDialer(dialer, "tcp", default_port(u.Host, "8883"), &tls.Config{ServerName: u.Hostname()})
+    default:
+        return nil, errors.New(fmt.Sprintf("[mqtt]Unknown scheme: %s", u.Scheme))
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    c := &Client{conn: conn, r: bufio.NewReader(conn), timeout: opts.Timeout}
+    err = c.connect(opts)
+    if err != nil {
+        conn.Close()
+        return nil, err
+    }
+    return c, nil
+}
+
+func default_port(host string, port string) string {
+    if _, _, err := net.SplitHostPort(host); err == nil {
+        return host
+    }
+    return net.JoinHostPort(host, port)
+}
+
+func encode_string(s string) []byte {
+    b := make([]byte, 2, 2 + len(s))
+    binary.BigEndian.PutUint16(b, uint16(len(s)))
+    return append(b, s...)
+}
+
+func encode_remaining_length(n int) []byte {
+    var b []byte
+    for {
+        digit := byte(n % 128)
+        n /= 128
+        if n > 0 {
+            digit |= 0x80
+        }
+        b = append(b, digit)
+        if n == 0 {
+            return b
+        }
+    }
+}
+
+func (c *Client) write_packet(header byte, body []byte) error {
+    if c.timeout > 0 {
+        c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+    }
+    packet := append([]byte{header}, encode_remaining_length(len(body))...)
+    packet = append(packet, body...)
+    _, err := c.conn.Write(packet)
+    return err
+}
+
+func (c *Client) read_packet() (byte, []byte, error) {
+    if c.timeout > 0 {
+        c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+    }
+    header, err := c.r.ReadByte()
+    if err != nil {
+        return 0, nil, err
+    }
+
+    length := 0
+    for multiplier := 1; ; multiplier *= 128 {
+        digit, err := c.r.ReadByte()
+        if err != nil {
+            return 0, nil, err
+        }
+        length += int(digit & 0x7f) * multiplier
+        if digit & 0x80 == 0 {
+            break
+        }
+        if multiplier > 128 * 128 * 128 {
+            return 0, nil, errors.New("[mqtt]Malformed remaining length")
+        }
+    }
+
+    body := make([]byte, length)
+    _, err = io.ReadFull(c.r, body)
+    if err != nil {
+        return 0, nil, err
+    }
+    return header, body, nil
+}
+
+func (c *Client) expect(packet_type byte) ([]byte, error) {
+    header, body, err := c.read_packet()
+    if err != nil {
+        return nil, err
+    }
+    if header >> 4 != packet_type {
+        return nil, errors.New(fmt.Sprintf("[mqtt]Unexpected packet type: %d (expected %d)", header >> 4, packet_type))
+    }
+    return body, nil
+}
+
+var connack_errors = map[byte]string{
+    1: "unacceptable protocol version",
+    2: "identifier rejected",
+    3: "server unavailable",
+    4: "bad user name or password",
+    5: "not authorized",
+}
+
+func (c *Client) connect(opts ConnectOptions) error {
+    flags := byte(0x02) // clean session
+    payload := encode_string(opts.ClientId)
+    if opts.Username != "" {
+        flags |= 0x80
+        payload = append(payload, encode_string(opts.Username)...)
+        if opts.Password != "" {
+            flags |= 0x40
+            payload = append(payload, encode_string(opts.Password)...)
+        }
+    }
+
+    body := encode_string("MQTT")
+    body = append(body, 4, flags, byte(keep_alive >> 8), byte(keep_alive & 0xff))
+    body = append(body, payload...)
+
+    err := c.write_packet(packet_connect << 4, body)
+    if err != nil {
+        return err
+    }
+
+    ack, err := c.expect(packet_connack)
+    if err != nil {
+        return err
+    }
+    if len(ack) != 2 {
+        return errors.New("[mqtt]Malformed CONNACK")
+    }
+    if ack[1] != 0 {
+        return errors.New(fmt.Sprintf("[mqtt]Connection refused: %s", connack_errors[ack[1]]))
+    }
+    return nil
+}
+
+func (c *Client) next_packet_id() uint16 {
+    c.packet_id++
+    if c.packet_id == 0 {
+        c.packet_id = 1
+    }
+    return c.packet_id
+}
+
+// QoS 1, 2の場合はブローカーが受け取るまで待つ
+func (c *Client) Publish(topic string, payload []byte, qos byte, retain bool) error {
+    if qos > 2 {
+        return errors.New(fmt.Sprintf("[mqtt]Invalid QoS: %d", qos))
+    }
+
+    header := byte(packet_publish << 4) | qos << 1
+    if retain {
+        header |= 0x01
+    }
+
+    body := encode_string(topic)
+    var id uint16
+    if qos > 0 {
+        id = c.next_packet_id()
+        body = binary.BigEndian.AppendUint16(body, id)
+    }
+    body = append(body, payload...)
+
+    err := c.write_packet(header, body)
+    if err != nil {
+        return err
+    }
+
+    switch qos {
+    case 1:
+        return c.expect_ack(packet_puback, id)
+    case 2:
+        err = c.expect_ack(packet_pubrec, id)
+        if err != nil {
+            return err
+        }
+        err = c.write_packet(packet_pubrel << 4 | 0x02, binary.BigEndian.AppendUint16(nil, id))
+        if err != nil {
+            return err
+        }
+        return c.expect_ack(packet_pubcomp, id)
+    }
+    return nil
+}
+
+func (c *Client) expect_ack(packet_type byte, id uint16) error {
+    body, err := c.expect(packet_type)
+    if err != nil {
+        return err
+    }
+    if len(body) < 2 || binary.BigEndian.Uint16(body) != id {
+        return errors.New(fmt.Sprintf("[mqtt]Unexpected packet id in ack (type %d)", packet_type))
+    }
+    return nil
+}
+
+func (c *Client) Disconnect() error {
+    err := c.write_packet(packet_disconnect << 4, nil)
+    close_err := c.conn.Close()
+    if err != nil {
+        return err
+    }
+    return close_err
+}
@@ -0,0 +1,271 @@
+package mqtt
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/binary"
+    "net"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// テスト用のブローカーが受け取ったPUBLISH
+type received struct {
+    topic string
+    qos byte
+    retain bool
+    payload []byte
+}
+
+// CONNECTにconnack_codeを返し、PUBLISHをQoSに従って受け取るだけのブローカー
+type fakeBroker struct {
+    listener net.Listener
+    connack_code byte
+
+    mu sync.Mutex
+    connects [][]byte
+    published []received
+    pubrel int
+}
+
+func new_fake_broker(t *testing.T, connack_code byte) *fakeBroker {
+    t.Helper()
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    b := &fakeBroker{listener: l, connack_code: connack_code}
+    go func() {
+        for {
+            conn, err := l.Accept()
+            if err != nil {
+                return
+            }
+            go b.serve(conn)
+        }
+    }()
+    t.Cleanup(func() { l.Close() })
+    return b
+}
+
+func (b *fakeBroker) Url() string {
+    return "tcp://" + b.listener.Addr().String()
+}
+
+func (b *fakeBroker) Published() []received {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return append([]received{}, b.published...)
+}
+
+// パケットの読み書きはクライアントと同じものを使う
+func (b *fakeBroker) serve(conn net.Conn) {
+    defer conn.Close()
+    c := &Client{conn: conn, r: bufio.NewReader(conn), timeout: 5 * time.Second}
+    for {
+        header, body, err := c.read_packet()
+        if err != nil {
+            return
+        }
+        switch header >> 4 {
+        case packet_connect:
+            b.mu.Lock()
+            b.connects = append(b.connects, body)
+            b.mu.Unlock()
+            c.write_packet(packet_connack << 4, []byte{0, b.connack_code})
+            if b.connack_code != 0 {
+                return
+            }
+        case packet_publish:
+            qos := header >> 1 & 0x03
+            n := int(binary.BigEndian.Uint16(body))
+            r := received{topic: string(body[2:2 + n]), qos: qos, retain: header & 0x01 != 0}
+            rest := body[2 + n:]
+            var id []byte
+            if qos > 0 {
+                id = rest[:2]
+                rest = rest[2:]
+            }
+            r.payload = rest
+            b.mu.Lock()
+            b.published = append(b.published, r)
+            b.mu.Unlock()
+            switch qos {
+            case 1:
+                c.write_packet(packet_puback << 4, id)
+            case 2:
+                c.write_packet(packet_pubrec << 4, id)
+            }
+        case packet_pubrel:
+            if header & 0x0f != 0x02 {
+                return
+            }
+            b.mu.Lock()
+            b.pubrel++
+            b.mu.Unlock()
+            c.write_packet(packet_pubcomp << 4, body)
+        case packet_disconnect:
+            return
+        }
+    }
+}
+
+func TestEncodeRemainingLength(t *testing.T) {
+    tests := []struct {
+        n int
+        want []byte
+    }{
+        {0, []byte{0x00}},
+        {127, []byte{0x7f}},
+        {128, []byte{0x80, 0x01}},
+        {321, []byte{0xc1, 0x02}},
+        {16383, []byte{0xff, 0x7f}},
+        {16384, []byte{0x80, 0x80, 0x01}},
+        {2097151, []byte{0xff, 0xff, 0x7f}},
+        {2097152, []byte{0x80, 0x80, 0x80, 0x01}},
+    }
+    for _, tt := range tests {
+        if got := encode_remaining_length(tt.n); !bytes.Equal(got, tt.want) {
+            t.Errorf("encode_remaining_length(%d) = %x, want %x", tt.n, got, tt.want)
+        }
+    }
+}
+
+func TestConnect(t *testing.T) {
+    b := new_fake_broker(t, 0)
+    c, err := Connect(b.Url(), ConnectOptions{ClientId: "client", Username: "user", Password: "pass", Timeout: 5 * time.Second})
+    if err != nil {
+        t.Fatal(err)
+    }
+    c.Disconnect()
+
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if len(b.connects) != 1 {
+        t.Fatalf("connects = %d", len(b.connects))
+    }
+    want := append(encode_string("MQTT"), 4, 0xc2, 0, keep_alive)
+    want = append(want, encode_string("client")...)
+    want = append(want, encode_string("user")...)
+    want = append(want, encode_string("pass")...)
+    if !bytes.Equal(b.connects[0], want) {
+        t.Errorf("CONNECT = %x\nwant      %x", b.connects[0], want)
+    }
+}
+
+func TestConnectRefused(t *testing.T) {
+    for code, reason := range connack_errors {
+        b := new_fake_broker(t, code)
+        _, err := Connect(b.Url(), ConnectOptions{ClientId: "client", Timeout: 5 * time.Second})
+        if err == nil || !strings.Contains(err.Error(), reason) {
+            t.Errorf("code %d: err = %v", code, err)
+        }
+    }
+}
+
+func TestConnectUnknownScheme(t *testing.T) {
+    _, err := Connect("http://localhost:1883", ConnectOptions{})
+    if err == nil {
+        t.Errorf("expected error")
+    }
+}
+
+func TestPublish(t *testing.T) {
+    long := []byte(strings.Repeat("x", 300))
+    tests := []struct {
+        name string
+        qos byte
+        retain bool
+        payload []byte
+    }{
+        {"qos 0", 0, false, []byte(`{"weight":60}`)},
+        {"qos 1 retain", 1, true, []byte(`{"weight":60}`)},
+        {"qos 2", 2, false, []byte(`{"weight":60}`)},
+        {"remaining length over 127", 1, false, long},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            b := new_fake_broker(t, 0)
+            c, err := Connect(b.Url(), ConnectOptions{ClientId: "client", Timeout: 5 * time.Second})
+            if err != nil {
+                t.Fatal(err)
+            }
+            // QoS 1, 2はackを受け取ってから戻る
+            err = c.Publish("a/b", tt.payload, tt.qos, tt.retain)
+            if err != nil {
+                t.Fatal(err)
+            }
+            err = c.Publish("a/c", tt.payload, tt.qos, tt.retain)
+            if err != nil {
+                t.Fatal(err)
+            }
+            c.Disconnect()
+
+            // QoS 0は受け取りを待たないので、ブローカーが読むまで待つ
+            deadline := time.Now().Add(5 * time.Second)
+            for len(b.Published()) < 2 && time.Now().Before(deadline) {
+                time.Sleep(10 * time.Millisecond)
+            }
+            got := b.Published()
+            if len(got) != 2 {
+                t.Fatalf("published %d", len(got))
+            }
+            for i, topic := range []string{"a/b", "a/c"} {
+                if got[i].topic != topic || got[i].qos != tt.qos || got[i].retain != tt.retain || !bytes.Equal(got[i].payload, tt.payload) {
+                    t.Errorf("published[%d] = %s qos=%d retain=%v len=%d", i, got[i].topic, got[i].qos, got[i].retain, len(got[i].payload))
+                }
+            }
+            b.mu.Lock()
+            if tt.qos == 2 && b.pubrel != 2 {
+                t.Errorf("pubrel = %d", b.pubrel)
+            }
+            b.mu.Unlock()
+        })
+    }
+}
+
+func TestPublishInvalidQos(t *testing.T) {
+    b := new_fake_broker(t, 0)
+    c, err := Connect(b.Url(), ConnectOptions{ClientId: "client", Timeout: 5 * time.Second})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer c.Disconnect()
+    if err := c.Publish("a", nil, 3, false); err == nil {
+        t.Errorf("expected error")
+    }
+}
+
+// ackのpacket idが違う場合はエラーにする
+func TestPublishWrongAck(t *testing.T) {
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer l.Close()
+    go func() {
+        conn, err := l.Accept()
+        if err != nil {
+            return
+        }
+        defer conn.Close()
+        s := &Client{conn: conn, r: bufio.NewReader(conn)}
+        s.read_packet()
+        s.write_packet(packet_connack << 4, []byte{0, 0})
+        s.read_packet()
+        s.write_packet(packet_puback << 4, []byte{0xff, 0xff})
+        s.read_packet()
+    }()
+
+    c, err := Connect("tcp://" + l.Addr().String(), ConnectOptions{ClientId: "client", Timeout: 5 * time.Second})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer c.Disconnect()
+    if err := c.Publish("a", []byte("x"), 1, false); err == nil {
+        t.Errorf("expected error")
+    }
+}
@@ -0,0 +1,200 @@
+package mqtt
+
+import (
+    "encoding/json"
+    "fmt"
+    "strings"
+    "time"
+    "log/slog"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+const (
+    default_client_id = "tanita_to_fitbit"
+    default_node_id = "tanita_to_fitbit"
+    default_topic = "tanita_to_fitbit/{node}/state"
+    default_discovery_prefix = "homeassistant"
+    default_timeout = 10
+)
+
+// MQTTブローカーに測定データを送る送信先の設定
+type Config struct {
+    Broker string `json:"broker"`
+    Username string `json:"username"`
+    Password string `json:"password"`
+    ClientId string `json:"client_id"`
+    Timeout int `json:"timeout"`
+
+    // {node}はnode_id、{model}は体組成計の機種に置き換える
+    Topic string `json:"topic"`
+    Qos byte `json:"qos"`
+    // 最後の測定データをretainする
+    Retain bool `json:"retain"`
+
+    // Home AssistantのMQTT Discovery
+    Discovery bool `json:"discovery"`
+    DiscoveryPrefix string `json:"discovery_prefix"`
+    NodeId string `json:"node_id"`
+    DeviceName string `json:"device_name"`
+
+    // ブローカーからは過去にpublishした測定を取得できないので、publishの結果を記録するファイル
+    StatusFile string `json:"status_file"`
+}
+
+type Sink struct {
+    conf Config
+    ledger *measurement.Ledger
+    // この実行でDiscoveryを送信済みのセンサー
+    discovered map[string]bool
+    Logger *slog.Logger
+}
+
+func NewSink(conf Config, logger *slog.Logger) (*Sink, error) {
+    if conf.ClientId == "" {
+        conf.ClientId = default_client_id
+    }
+    if conf.NodeId == "" {
+        conf.NodeId = default_node_id
+    }
+    if conf.DeviceName == "" {
+        conf.DeviceName = conf.NodeId
+    }
+    if conf.Topic == "" {
+        conf.Topic = default_topic
+    }
+    if conf.DiscoveryPrefix == "" {
+        conf.DiscoveryPrefix = default_discovery_prefix
+    }
+    if conf.Timeout == 0 {
+        conf.Timeout = default_timeout
+    }
+
+    ledger, err := measurement.LoadLedger(conf.StatusFile)
+    if err != nil {
+        return nil, err
+    }
+
+    return &Sink{conf: conf, ledger: ledger, discovered: make(map[string]bool), Logger: logger}, nil
+}
+
+func (s *Sink) Name() string {
+    return "mqtt"
+}
+
+// publishに成功した測定を記録済みとする (measurement.Sink)
+// retainしていてもブローカーに残るのはtopicごとに最新の1件だけなので、ブローカーには問い合わせない
+func (s *Sink) Existing(date time.Time) ([]measurement.BodyMeasurement, error) {
+    return s.ledger.Existing(date), nil
+}
+
+func (s *Sink) Topic(m measurement.BodyMeasurement) string {
+    r := strings.NewReplacer("{node}", s.conf.NodeId, "{model}", m.Model)
+    return r.Replace(s.conf.Topic)
+}
+
+// Home Assistantのセンサー定義
+type sensor struct {
+    key string
+    name string
+    unit string
+    device_class string
+    value func(m measurement.BodyMeasurement) float64
+}
+
+var sensors = []sensor{
+    {"weight", "Weight", "kg", "weight", func(m measurement.BodyMeasurement) float64 { return m.Weight }},
+    {"body_fat", "Body fat", "%", "", func(m measurement.BodyMeasurement) float64 { return m.BodyFat }},
+    {"bmi", "BMI", "", "", func(m measurement.BodyMeasurement) float64 { return m.BMI }},
+    {"muscle_mass", "Muscle mass", "kg", "weight", func(m measurement.BodyMeasurement) float64 { return m.MuscleMass }},
+    {"muscle_score", "Muscle score", "", "", func(m measurement.BodyMeasurement) float64 { return m.MuscleScore }},
+    {"visceral_fat_level", "Visceral fat level", "", "", func(m measurement.BodyMeasurement) float64 { return m.VisceralFatLevel }},
+    {"basal_metabolic_rate", "Basal metabolic rate", "kcal", "", func(m measurement.BodyMeasurement) float64 { return m.BasalMetabolicRate }},
+    {"body_age", "Body age", "", "", func(m measurement.BodyMeasurement) float64 { return m.BodyAge }},
+    {"bone_mass", "Bone mass", "kg", "weight", func(m measurement.BodyMeasurement) float64 { return m.BoneMass }},
+}
+
+// 測定データに含まれる項目のDiscoveryメッセージ(トピックと内容)を返す
+func (s *Sink) DiscoveryMessages(m measurement.BodyMeasurement) (map[string][]byte, error) {
+    state_topic := s.Topic(m)
+    object_prefix := s.conf.NodeId
+    if strings.Contains(s.conf.Topic, "{model}") && m.Model != "" {
+        object_prefix = object_prefix + "_" + m.Model
+    }
+
+    ret := make(map[string][]byte)
+    for _, sn := range sensors {
+        if sn.value(m) == 0 {
+            continue
+        }
+        config := map[string]any{
+            "name": sn.name,
+            "unique_id": object_prefix + "_" + sn.key,
+            "state_topic": state_topic,
+            "value_template": fmt.Sprintf("{{ value_json.%s }}", sn.key),
+            "state_class": "measurement",
+            "device": map[string]any{
+                "identifiers": []string{s.conf.NodeId},
+                "name": s.conf.DeviceName,
+                "manufacturer": "Tanita",
+            },
+        }
+        if sn.unit != "" {
+            config["unit_of_measurement"] = sn.unit
+        }
+        if sn.device_class != "" {
+            config["device_class"] = sn.device_class
+        }
+        data, err := json.Marshal(config)
+        if err != nil {
+            return nil, err
+        }
+        ret[fmt.Sprintf("%s/sensor/%s/%s/config", s.conf.DiscoveryPrefix, object_prefix, sn.key)] = data
+    }
+    return ret, nil
+}
+
+// 測定ごとに接続してpublishし、結果を記録する
+// その場では再試行しないので試行回数は常に1で、失敗した測定は次の同期で送り直す
+func (s *Sink) Write(m measurement.BodyMeasurement) error {
+    return s.ledger.Record(m, 1, s.publish(m))
+}
+
+func (s *Sink) publish(m measurement.BodyMeasurement) error {
+    payload, err := json.Marshal(m)
+    if err != nil {
+        return err
+    }
+
+    c, err := Connect(s.conf.Broker, ConnectOptions{
+        ClientId: s.conf.ClientId,
+        Username: s.conf.Username,
+        Password: s.conf.Password,
+        Timeout: time.Duration(s.conf.Timeout) * time.Second,
+    })
+    if err != nil {
+        return err
+    }
+    defer c.Disconnect()
+
+    if s.conf.Discovery {
+        messages, err := s.DiscoveryMessages(m)
+        if err != nil {
+            return err
+        }
+        for topic, data := range messages {
+            if s.discovered[topic] {
+                continue
+            }
+            // Discoveryは常にretainする
+            err = c.Publish(topic, data, s.conf.Qos, true)
+            if err != nil {
+                return err
+            }
+            s.discovered[topic] = true
+        }
+    }
+
+    topic := s.Topic(m)
+    s.Logger.Debug(fmt.Sprintf("[mqtt]Publish %s: %s", topic, payload))
+    return c.Publish(topic, payload, s.conf.Qos, s.conf.Retain)
+}
@@ -0,0 +1,149 @@
+package mqtt
+
+import (
+    "encoding/json"
+    "io"
+    "log/slog"
+    "path/filepath"
+    "testing"
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+var test_date = time.Date(2024, 1, 2, 7, 30, 0, 0, time.FixedZone("JST", 9 * 60 * 60))
+
+func new_test_sink(t *testing.T, conf Config) *Sink {
+    t.Helper()
+    conf.StatusFile = filepath.Join(t.TempDir(), "mqtt.json")
+    s, err := NewSink(conf, slog.New(slog.NewTextHandler(io.Discard, nil)))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return s
+}
+
+func TestTopic(t *testing.T) {
+    s := new_test_sink(t, Config{NodeId: "alice", Topic: "scale/{node}/{model}"})
+    if got := s.Topic(measurement.BodyMeasurement{Model: "01000144"}); got != "scale/alice/01000144" {
+        t.Errorf("topic = %s", got)
+    }
+    s = new_test_sink(t, Config{})
+    if got := s.Topic(measurement.BodyMeasurement{}); got != "tanita_to_fitbit/tanita_to_fitbit/state" {
+        t.Errorf("default topic = %s", got)
+    }
+}
+
+func TestDiscoveryMessages(t *testing.T) {
+    s := new_test_sink(t, Config{NodeId: "alice", DeviceName: "Alice scale", Topic: "scale/{node}/{model}"})
+    messages, err := s.DiscoveryMessages(measurement.BodyMeasurement{Date: test_date, Weight: 60, BodyFat: 20.1, Model: "01000144"})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // 値の無い項目のセンサーは作らない
+    if len(messages) != 2 {
+        t.Fatalf("messages = %v", messages)
+    }
+    data, ok := messages["homeassistant/sensor/alice_01000144/weight/config"]
+    if !ok {
+        t.Fatalf("no weight sensor: %v", messages)
+    }
+    var config map[string]any
+    err = json.Unmarshal(data, &config)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := map[string]string{
+        "name": "Weight",
+        "unique_id": "alice_01000144_weight",
+        "state_topic": "scale/alice/01000144",
+        "value_template": "{{ value_json.weight }}",
+        "unit_of_measurement": "kg",
+        "device_class": "weight",
+        "state_class": "measurement",
+    }
+    for k, v := range want {
+        if config[k] != v {
+            t.Errorf("%s = %v, want %s", k, config[k], v)
+        }
+    }
+    device := config["device"].(map[string]any)
+    if device["name"] != "Alice scale" || device["identifiers"].([]any)[0] != "alice" {
+        t.Errorf("device = %v", device)
+    }
+
+    data = messages["homeassistant/sensor/alice_01000144/body_fat/config"]
+    config = nil
+    json.Unmarshal(data, &config)
+    if config["unit_of_measurement"] != "%" {
+        t.Errorf("body_fat unit = %v", config["unit_of_measurement"])
+    }
+    if _, ok := config["device_class"]; ok {
+        t.Errorf("body_fat has device_class")
+    }
+}
+
+func TestWrite(t *testing.T) {
+    b := new_fake_broker(t, 0)
+    s := new_test_sink(t, Config{Broker: b.Url(), Qos: 1, Retain: true, Discovery: true, DiscoveryPrefix: "ha"})
+
+    m := measurement.BodyMeasurement{Date: test_date, Weight: 60, BodyFat: 20.1}
+    err := s.Write(m)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = s.Write(measurement.BodyMeasurement{Date: test_date.Add(time.Hour), Weight: 60.2, BodyFat: 20})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Discoveryは最初の1回だけ、常にretainで送る
+    got := b.Published()
+    if len(got) != 4 {
+        t.Fatalf("published %d", len(got))
+    }
+    topics := map[string]bool{}
+    for _, r := range got[:2] {
+        topics[r.topic] = true
+        if !r.retain || r.qos != 1 {
+            t.Errorf("discovery %s retain=%v qos=%d", r.topic, r.retain, r.qos)
+        }
+    }
+    if !topics["ha/sensor/tanita_to_fitbit/weight/config"] || !topics["ha/sensor/tanita_to_fitbit/body_fat/config"] {
+        t.Errorf("discovery topics = %v", topics)
+    }
+    for _, r := range got[2:] {
+        if r.topic != "tanita_to_fitbit/tanita_to_fitbit/state" || !r.retain || r.qos != 1 {
+            t.Errorf("state %s retain=%v qos=%d", r.topic, r.retain, r.qos)
+        }
+    }
+    var state measurement.BodyMeasurement
+    err = json.Unmarshal(got[2].payload, &state)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !state.Date.Equal(m.Date) || state.Weight != 60 || state.BodyFat != 20.1 {
+        t.Errorf("state = %s", &state)
+    }
+
+    // 送信済みのデータは送信履歴から分かる
+    existing, err := s.Existing(test_date)
+    if err != nil || len(existing) != 2 {
+        t.Errorf("existing = %v, err = %v", existing, err)
+    }
+}
+
+func TestWriteFailed(t *testing.T) {
+    b := new_fake_broker(t, 5)
+    s := new_test_sink(t, Config{Broker: b.Url(), Timeout: 5})
+
+    err := s.Write(measurement.BodyMeasurement{Date: test_date, Weight: 60})
+    if err == nil {
+        t.Fatal("expected error")
+    }
+    // 失敗した送信は送信済みにしない (次の同期で再送する)
+    existing, _ := s.Existing(test_date)
+    if len(existing) != 0 {
+        t.Errorf("existing = %v", existing)
+    }
+}
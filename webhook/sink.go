@@ -0,0 +1,164 @@
+package webhook
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "time"
+    "log/slog"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+const (
+    default_signature_header = "X-Signature-256"
+    default_max_retries = 3
+    default_retry_interval = 2
+    default_timeout = 10
+)
+
+// 新しい測定データをJSONでPOSTする送信先の設定
+type Config struct {
+    Url string `json:"url"`
+    // 指定した場合はボディのHMAC-SHA256を "sha256=<hex>" の形式でsignature_headerに付ける
+    Secret string `json:"secret"`
+    SignatureHeader string `json:"signature_header"`
+    Headers map[string]string `json:"headers"`
+
+    // 失敗した場合の再試行回数と間隔(秒、小数可、再試行ごとに2倍にする)
+    // 0を指定できるように、省略した場合(nil)だけ既定値にする
+    MaxRetries *int `json:"max_retries"`
+    RetryInterval *float64 `json:"retry_interval"`
+    Timeout int `json:"timeout"`
+
+    // 受信側に記録を問い合わせる方法が無いので、送信した測定を記録しておくファイル
+    StatusFile string `json:"status_file"`
+}
+
+type Sink struct {
+    conf Config
+    max_retries int
+    retry_interval time.Duration
+    ledger *measurement.Ledger
+    client *http.Client
+    Logger *slog.Logger
+}
+
+func NewSink(conf Config, logger *slog.Logger) (*Sink, error) {
+    if conf.SignatureHeader == "" {
+        conf.SignatureHeader = default_signature_header
+    }
+    max_retries := default_max_retries
+    if conf.MaxRetries != nil {
+        max_retries = *conf.MaxRetries
+    }
+    retry_interval := default_retry_interval * time.Second
+    if conf.RetryInterval != nil {
+        retry_interval = time.Duration(*conf.RetryInterval * float64(time.Second))
+    }
+    if max_retries < 0 || retry_interval < 0 {
+        return nil, errors.New("[webhook]max_retries and retry_interval must not be negative")
+    }
+    if conf.Timeout == 0 {
+        conf.Timeout = default_timeout
+    }
+
+    ledger, err := measurement.LoadLedger(conf.StatusFile)
+    if err != nil {
+        return nil, err
+    }
+
+    return &Sink{
+        conf: conf,
+        max_retries: max_retries,
+        retry_interval: retry_interval,
+        ledger: ledger,
+        client: &http.Client{Timeout: time.Duration(conf.Timeout) * time.Second},
+        Logger: logger,
+    }, nil
+}
+
+func (s *Sink) Name() string {
+    return "webhook"
+}
+
+// POSTに成功した測定を記録済みとする (measurement.Sink)
+// 失敗したものは含めないので、次の同期で再送する
+func (s *Sink) Existing(date time.Time) ([]measurement.BodyMeasurement, error) {
+    return s.ledger.Existing(date), nil
+}
+
+func Sign(secret string, body []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write(body)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// 測定データをJSONでPOSTし、再試行を含めた試行回数と結果を記録する
+func (s *Sink) Write(m measurement.BodyMeasurement) error {
+    body, err := json.Marshal(m)
+    if err != nil {
+        return err
+    }
+
+    attempts, err := s.post(body)
+    return s.ledger.Record(m, attempts, err)
+}
+
+type retryableError struct {
+    error
+}
+
+// 通信エラー、429、5xxの場合は間隔を空けて再試行する
+func (s *Sink) post(body []byte) (int, error) {
+    interval := s.retry_interval
+    var err error
+    for attempt := 1; ; attempt++ {
+        err = s.post_once(body)
+        if err == nil {
+            return attempt, nil
+        }
+        var re retryableError
+        if !errors.As(err, &re) || attempt > s.max_retries {
+            return attempt, err
+        }
+        s.Logger.Warn(fmt.Sprintf("[webhook]Failed to post (attempt %d), retry after %s: %s", attempt, interval, err))
+        time.Sleep(interval)
+        interval *= 2
+    }
+}
+
+func (s *Sink) post_once(body []byte) error {
+    req, err := http.NewRequest("POST", s.conf.Url, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    for k, v := range s.conf.Headers {
+        req.Header.Set(k, v)
+    }
+    if s.conf.Secret != "" {
+        req.Header.Set(s.conf.SignatureHeader, Sign(s.conf.Secret, body))
+    }
+
+    resp, err := s.client.Do(req)
+    if err != nil {
+        return retryableError{err}
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+        return nil
+    }
+    resp_body, _ := ioutil.ReadAll(resp.Body)
+    err = errors.New(fmt.Sprintf("[webhook]Failed to post: (%d) %s", resp.StatusCode, resp_body))
+    if resp.StatusCode == 429 || resp.StatusCode >= 500 {
+        return retryableError{err}
+    }
+    return err
+}
@@ -0,0 +1,197 @@
+package webhook
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "io"
+    "log/slog"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "testing"
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+var test_date = time.Date(2024, 1, 2, 7, 30, 0, 0, time.FixedZone("JST", 9 * 60 * 60))
+
+func new_test_sink(t *testing.T, conf Config) *Sink {
+    t.Helper()
+    conf.StatusFile = filepath.Join(t.TempDir(), "webhook.json")
+    s, err := NewSink(conf, slog.New(slog.NewTextHandler(io.Discard, nil)))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return s
+}
+
+// statusesの順に応答するwebhookの受信側 (statusesを使い切った後は200)
+func new_test_server(t *testing.T, statuses []int, requests *int) *httptest.Server {
+    t.Helper()
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        *requests++
+        if *requests <= len(statuses) {
+            w.WriteHeader(statuses[*requests - 1])
+        }
+    }))
+    t.Cleanup(srv.Close)
+    return srv
+}
+
+func ptr[T any](v T) *T {
+    return &v
+}
+
+func TestWriteSignature(t *testing.T) {
+    var body []byte
+    var header http.Header
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ = io.ReadAll(r.Body)
+        header = r.Header
+    }))
+    defer srv.Close()
+
+    s := new_test_sink(t, Config{Url: srv.URL, Secret: "secret", Headers: map[string]string{"X-Token": "abc"}})
+    m := measurement.BodyMeasurement{Date: test_date, Weight: 60.5, BodyFat: 20.1, Model: "RD-907"}
+    err := s.Write(m)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // 受け取ったボディそのもののHMAC-SHA256
+    mac := hmac.New(sha256.New, []byte("secret"))
+    mac.Write(body)
+    if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); header.Get(default_signature_header) != want {
+        t.Errorf("signature = %q, want %q", header.Get(default_signature_header), want)
+    }
+    if header.Get("Content-Type") != "application/json" || header.Get("X-Token") != "abc" {
+        t.Errorf("header = %v", header)
+    }
+    var got measurement.BodyMeasurement
+    if err := json.Unmarshal(body, &got); err != nil || !got.Date.Equal(m.Date) || got.Weight != m.Weight || got.Model != m.Model {
+        t.Errorf("body = %s, err = %v", body, err)
+    }
+
+    // secretが無い場合は署名を付けない
+    s = new_test_sink(t, Config{Url: srv.URL, SignatureHeader: "X-Hub-Signature-256"})
+    err = s.Write(m)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if header.Get("X-Hub-Signature-256") != "" || header.Get(default_signature_header) != "" {
+        t.Errorf("signed without secret: %v", header)
+    }
+}
+
+func TestWriteRetry(t *testing.T) {
+    tests := []struct {
+        name string
+        max_retries *int
+        statuses []int
+        requests int
+        ok bool
+    }{
+        {"success", nil, nil, 1, true},
+        {"server error", nil, []int{500, 502}, 3, true},
+        {"too many requests", nil, []int{429}, 2, true},
+        {"give up", ptr(2), []int{503, 503, 503, 503}, 3, false},
+        // 429と5xx以外の4xxは再送しても変わらないので再試行しない
+        {"bad request", nil, []int{400}, 1, false},
+        {"not found", nil, []int{404}, 1, false},
+        // 0を指定した場合は再試行しない
+        {"no retries", ptr(0), []int{500}, 1, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            requests := 0
+            srv := new_test_server(t, tt.statuses, &requests)
+            s := new_test_sink(t, Config{Url: srv.URL, MaxRetries: tt.max_retries, RetryInterval: ptr(0.001)})
+
+            err := s.Write(measurement.BodyMeasurement{Date: test_date, Weight: 60})
+            if (err == nil) != tt.ok {
+                t.Errorf("err = %v", err)
+            }
+            if requests != tt.requests {
+                t.Errorf("requests = %d, want %d", requests, tt.requests)
+            }
+            if d := s.ledger.Deliveries[0]; d.Attempts != tt.requests {
+                t.Errorf("attempts = %d, want %d", d.Attempts, tt.requests)
+            }
+        })
+    }
+}
+
+func TestWriteRetryNetworkError(t *testing.T) {
+    // 接続できないアドレス
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    addr := l.Addr().String()
+    l.Close()
+
+    s := new_test_sink(t, Config{Url: "http://" + addr, MaxRetries: ptr(2), RetryInterval: ptr(0.05)})
+    start := time.Now()
+    err = s.Write(measurement.BodyMeasurement{Date: test_date, Weight: 60})
+    if err == nil {
+        t.Fatal("expected error")
+    }
+    // 1秒未満の間隔も指定でき、再試行ごとに2倍にする (0.05s + 0.1s)
+    if elapsed := time.Since(start); elapsed < 150 * time.Millisecond || elapsed > 5 * time.Second {
+        t.Errorf("elapsed = %s", elapsed)
+    }
+    if d := s.ledger.Deliveries[0]; d.Attempts != 3 || d.Status != measurement.StatusFailed {
+        t.Errorf("delivery = %+v", d)
+    }
+}
+
+func TestWriteLedger(t *testing.T) {
+    requests := 0
+    srv := new_test_server(t, []int{400}, &requests)
+    conf := Config{Url: srv.URL}
+    s := new_test_sink(t, conf)
+    m := measurement.BodyMeasurement{Date: test_date, Weight: 60, Model: "RD-907"}
+
+    // 失敗した送信も履歴には残すが、送信済みにはしない
+    err := s.Write(m)
+    if err == nil {
+        t.Fatal("expected error")
+    }
+    ledger, err := measurement.LoadLedger(s.conf.StatusFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(ledger.Deliveries) != 1 || ledger.Deliveries[0].Status != measurement.StatusFailed || ledger.Deliveries[0].LastError == "" {
+        t.Fatalf("deliveries = %+v", ledger.Deliveries)
+    }
+    existing, err := s.Existing(test_date)
+    if err != nil || len(existing) != 0 {
+        t.Errorf("existing = %v, err = %v", existing, err)
+    }
+
+    // 次の同期で再送に成功すると送信済みになる
+    err = s.Write(m)
+    if err != nil {
+        t.Fatal(err)
+    }
+    existing, err = s.Existing(test_date)
+    if err != nil || len(existing) != 1 || existing[0].Weight != 60 || existing[0].Model != "RD-907" {
+        t.Errorf("existing = %v, err = %v", existing, err)
+    }
+    if d := s.ledger.Deliveries[0]; d.Attempts != 2 || d.Status != measurement.StatusDelivered || d.LastError != "" {
+        t.Errorf("delivery = %+v", d)
+    }
+}
+
+func TestNewSinkInvalid(t *testing.T) {
+    logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+    for _, conf := range []Config{{MaxRetries: ptr(-1)}, {RetryInterval: ptr(-1.0)}} {
+        conf.StatusFile = filepath.Join(t.TempDir(), "webhook.json")
+        if _, err := NewSink(conf, logger); err == nil {
+            t.Errorf("expected error for %+v", conf)
+        }
+    }
+}
@@ -0,0 +1,425 @@
+package withings
+
+import (
+    "bufio"
+    "fmt"
+    "net/url"
+    "net/http"
+    "io/ioutil"
+    "os"
+    "encoding/json"
+    "errors"
+    "math"
+    "strings"
+    "time"
+    "log/slog"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+// 計測の種類 (meastype)
+const (
+    MeasTypeWeight = 1
+    MeasTypeFatRatio = 6
+)
+
+// 計測の区分 (category)
+const (
+    CategoryReal = 1
+    CategoryObjective = 2
+)
+
+// 有効期限のこれだけ前になったらリフレッシュする (アクセストークンの有効期限は3時間)
+const TokenRefreshThreshold = 10 * 60
+
+type Auth struct {
+    url string
+    auth_url string
+    client_id string
+    client_secret string
+
+    token *Token
+    dump_filepath string
+}
+
+
+type Token struct {
+    Access_token string `json:"access_token"`
+    Refresh_token string `json:"refresh_token"`
+    Expires_in int64 `json:"expires_in"`
+    Scope string `json:"scope"`
+    Token_type string `json:"token_type"`
+    User_id json.Number `json:"userid"`
+
+    Create_date int64 `json:"create_date,omitempty"`
+}
+
+
+// WithingsのAPIはHTTPステータスではなくstatusでエラーを返す (0が成功)
+type response struct {
+    Status int `json:"status"`
+    Error string `json:"error"`
+    Body json.RawMessage `json:"body"`
+}
+
+type MeasureResponse struct {
+    Timezone string `json:"timezone"`
+    MeasureGroups []struct {
+        GrpId int64 `json:"grpid"`
+        Attrib int `json:"attrib"`
+        Date int64 `json:"date"`
+        Category int `json:"category"`
+        DeviceId string `json:"deviceid"`
+        Measures []Measure `json:"measures"`
+    } `json:"measuregrps"`
+}
+
+// 値はvalue * 10^unit
+type Measure struct {
+    Value int64 `json:"value"`
+    Type int `json:"type"`
+    Unit int `json:"unit"`
+}
+
+// 0.001のような値は2進数で正確に表せないので、unitが負の場合は割り算にする (60250 * 10^-3 = 60.25)
+func (m *Measure) Float() float64 {
+    if m.Unit < 0 {
+        return float64(m.Value) / math.Pow10(-m.Unit)
+    }
+    return float64(m.Value) * math.Pow10(m.Unit)
+}
+
+func NewMeasure(meas_type int, value float64, unit int) Measure {
+    return Measure{Value: int64(math.Round(value * math.Pow10(-unit))), Type: meas_type, Unit: unit}
+}
+
+
+type Client struct {
+    url string
+    auth *Auth
+    logger *slog.Logger
+    Timezone *time.Location
+}
+
+
+type WeightLog struct {
+    Date time.Time
+    Weight float64
+    Fat float64
+    GrpId int64
+}
+
+func (w *MeasureResponse) ToWeightLog(timezone *time.Location) []WeightLog {
+    var weight_logs []WeightLog
+    for _, grp := range w.MeasureGroups {
+        wl := WeightLog{Date: time.Unix(grp.Date, 0).In(timezone), GrpId: grp.GrpId}
+        for _, m := range grp.Measures {
+            switch m.Type {
+            case MeasTypeWeight:
+                wl.Weight = m.Float()
+            case MeasTypeFatRatio:
+                wl.Fat = m.Float()
+            }
+        }
+        weight_logs = append(weight_logs, wl)
+    }
+    return weight_logs
+}
+
+func (w *WeightLog) String() string {
+    return fmt.Sprintf("(%s)Weight: %f, Fat: %f", w.Date, w.Weight, w.Fat)
+}
+
+
+// Create_dateが0の場合(未取得のトークン)は有効期限が分からない
+func (t *Token) ExpiresAt() (time.Time, bool) {
+    if t.Create_date == 0 {
+        return time.Time{}, false
+    }
+    return time.Unix(t.Create_date + t.Expires_in, 0), true
+}
+
+func (t *Token) IsTokenExpired() bool {
+    expires_at, ok := t.ExpiresAt()
+    if !ok {
+        return false
+    }
+    return expires_at.Before(time.Now())
+}
+
+func (t *Token) IsTokenNeedRefresh() bool {
+    return t.Create_date + t.Expires_in - TokenRefreshThreshold < time.Now().Unix()
+}
+
+func (t *Token) HasScope(scope string) bool {
+    for _, s := range strings.Split(t.Scope, ",") {
+        if strings.TrimSpace(s) == scope {
+            return true
+        }
+    }
+    return false
+}
+
+
+// urlはAPI (wbsapi.withings.net)、auth_urlは認可画面 (account.withings.com)
+func NewAuth(url string, auth_url string, client_id string, client_secret string, dump_filepath string) *Auth {
+    auth := Auth{
+        url: url,
+        auth_url: auth_url,
+        client_id: client_id,
+        client_secret: client_secret,
+        dump_filepath: dump_filepath,
+        token: nil,
+    }
+    auth.token = &Token{Create_date: 0}
+
+    return &auth
+}
+
+func (a *Auth) GetAuthURL() (string, error) {
+    u, err := url.Parse(a.auth_url)
+    if err != nil {
+        return "", err
+    }
+
+    u.Path = "/oauth2_user/authorize2"
+
+    q := u.Query()
+    q.Set("response_type", "code")
+    q.Set("client_id", a.client_id)
+    q.Set("redirect_uri", "http://localhost")
+    q.Set("scope", "user.metrics")
+    q.Set("state", "tanita_to_fitbit")
+    u.RawQuery = q.Encode()
+
+    return u.String(), nil
+}
+
+func (a *Auth) InitToken() error {
+    if _, err := os.Stat(a.dump_filepath); err == nil {
+        return errors.New("[withings]Token file already exists. If you want to reinitialize, please remove token file")
+    }
+
+    auth_url, err := a.GetAuthURL()
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Access to: %s\n", auth_url)
+
+    // 認可後にリダイレクトされたURL(http://localhost/?code=...)のcodeを入力してもらう
+    fmt.Printf("and enter the code:")
+    scanner := bufio.NewScanner(os.Stdin)
+    scanner.Scan()
+    code := strings.TrimSpace(scanner.Text())
+
+    q := url.Values{}
+    q.Set("grant_type", "authorization_code")
+    q.Set("code", code)
+    q.Set("redirect_uri", "http://localhost")
+    err = a.request_token(q)
+    if err != nil {
+        return err
+    }
+
+    fmt.Println("Success to init token")
+    return nil
+}
+
+
+func (a *Auth) Token() *Token {
+    return a.token
+}
+
+func (a *Auth) LoadToken() error {
+    data, err := ioutil.ReadFile(a.dump_filepath)
+    if err != nil {
+        return err
+    }
+
+    a.token = &Token{}
+    err = json.Unmarshal(data, a.token)
+    if err != nil {
+        return err
+    }
+    return nil
+}
+
+func (a *Auth) DumpToken() error {
+    data, err := json.MarshalIndent(a.token, "", "  ")
+    if err != nil {
+        return err
+    }
+
+    f, err := os.OpenFile(a.dump_filepath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    _, err = f.Write(data)
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
+func (a *Auth) RefreshToken() error {
+    if !a.token.IsTokenNeedRefresh() {
+        return nil
+    }
+    return a.ForceRefreshToken()
+}
+
+// 有効期限に関わらずトークンをリフレッシュする
+// リフレッシュトークンも毎回新しくなるので、必ずファイルに書き戻す
+func (a *Auth) ForceRefreshToken() error {
+    q := url.Values{}
+    q.Set("grant_type", "refresh_token")
+    q.Set("refresh_token", a.token.Refresh_token)
+    return a.request_token(q)
+}
+
+func (a *Auth) request_token(q url.Values) error {
+    u, err := url.Parse(a.url)
+    if err != nil {
+        return err
+    }
+
+    u.Path = "/v2/oauth2"
+    q.Set("action", "requesttoken")
+    q.Set("client_id", a.client_id)
+    q.Set("client_secret", a.client_secret)
+
+    body, err := post(u.String(), "", q)
+    if err != nil {
+        return errors.New(fmt.Sprintf("[withings]Failed to get token: %s", err))
+    }
+
+    token := Token{}
+    err = json.Unmarshal(body, &token)
+    if err != nil {
+        return err
+    }
+    token.Create_date = time.Now().Unix()
+    a.token = &token
+
+    err = a.DumpToken()
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
+// 失効はさせずに保存したトークンファイルだけを消す
+// 連携の解除はWithingsのアカウント設定から行う
+func (a *Auth) RemoveToken(archive bool) (string, error) {
+    return measurement.RemoveFile(a.dump_filepath, archive)
+}
+
+
+// application/x-www-form-urlencodedでPOSTし、statusが0の場合はbodyを返す
+func post(_url string, access_token string, q url.Values) ([]byte, error) {
+    req, err := http.NewRequest("POST", _url, strings.NewReader(q.Encode()))
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    if access_token != "" {
+        req.Header.Set("Authorization", "Bearer " + access_token)
+    }
+
+    client := &http.Client{}
+    resp, err := client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    body, _ := ioutil.ReadAll(resp.Body)
+    if resp.StatusCode != 200 {
+        return nil, errors.New(fmt.Sprintf("(%d) %s", resp.StatusCode, body))
+    }
+
+    r := response{}
+    err = json.Unmarshal(body, &r)
+    if err != nil {
+        return nil, err
+    }
+    if r.Status != 0 {
+        return nil, errors.New(fmt.Sprintf("status %d %s", r.Status, r.Error))
+    }
+
+    return r.Body, nil
+}
+
+
+func NewClient(url string, auth *Auth, logger *slog.Logger, timezone *time.Location) *Client {
+    return &Client{url: url, auth: auth, logger: logger, Timezone: timezone}
+}
+
+func (c *Client) measure(q url.Values) ([]byte, error) {
+    u, err := url.Parse(c.url)
+    if err != nil {
+        return nil, err
+    }
+    u.Path = "/measure"
+
+    c.logger.Debug(fmt.Sprintf("[withings]Measure: %s %s", u.String(), q.Encode()))
+    body, err := post(u.String(), c.auth.token.Access_token, q)
+    if err != nil {
+        return nil, err
+    }
+    c.logger.Debug(fmt.Sprintf("[withings]Response: %s", body))
+
+    return body, nil
+}
+
+// start〜end(unix時刻、両端を含む)の体重・体脂肪率の記録を返す
+func (c *Client) GetMeasures(start time.Time, end time.Time) (*MeasureResponse, error) {
+    q := url.Values{}
+    q.Set("action", "getmeas")
+    q.Set("meastypes", fmt.Sprintf("%d,%d", MeasTypeWeight, MeasTypeFatRatio))
+    q.Set("category", fmt.Sprintf("%d", CategoryReal))
+    q.Set("startdate", fmt.Sprintf("%d", start.Unix()))
+    q.Set("enddate", fmt.Sprintf("%d", end.Unix()))
+
+    body, err := c.measure(q)
+    if err != nil {
+        return nil, errors.New(fmt.Sprintf("[withings]Failed to get measures: %s", err))
+    }
+
+    measures := MeasureResponse{}
+    err = json.Unmarshal(body, &measures)
+    if err != nil {
+        return nil, err
+    }
+
+    return &measures, nil
+}
+
+// 体重と体脂肪率を1つの計測グループとして記録する (fatが0以下の場合は体重のみ)
+// WithingsのAPIリファレンスに計測の書き込みは載っておらず、setmeasは書き込みを許可されたアプリケーションでないと使えない
+func (c *Client) CreateMeasure(date time.Time, weight float64, fat float64) error {
+    measures := []Measure{NewMeasure(MeasTypeWeight, weight, -3)}
+    if fat > 0 {
+        measures = append(measures, NewMeasure(MeasTypeFatRatio, fat, -2))
+    }
+    data, err := json.Marshal(measures)
+    if err != nil {
+        return err
+    }
+
+    q := url.Values{}
+    q.Set("action", "setmeas")
+    q.Set("date", fmt.Sprintf("%d", date.Unix()))
+    q.Set("category", fmt.Sprintf("%d", CategoryReal))
+    q.Set("measures", string(data))
+
+    _, err = c.measure(q)
+    if err != nil {
+        return errors.New(fmt.Sprintf("[withings]Failed to create measure: %s", err))
+    }
+
+    return nil
+}
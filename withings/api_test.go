@@ -0,0 +1,272 @@
+package withings
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+// 受け取ったリクエストを記録し、パスごとに決めた応答を返すWithings APIのスタブ
+type fakeAPI struct {
+    mu sync.Mutex
+    requests []url.Values
+    paths []string
+    auth []string
+    responses map[string]string
+}
+
+func new_fake_api(t *testing.T, responses map[string]string) (*fakeAPI, *httptest.Server) {
+    t.Helper()
+    f := &fakeAPI{responses: responses}
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != "POST" || r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+            w.WriteHeader(400)
+            return
+        }
+        r.ParseForm()
+        f.mu.Lock()
+        f.requests = append(f.requests, r.PostForm)
+        f.paths = append(f.paths, r.URL.Path)
+        f.auth = append(f.auth, r.Header.Get("Authorization"))
+        f.mu.Unlock()
+        body, ok := f.responses[r.URL.Path + "?" + r.PostForm.Get("action")]
+        if !ok {
+            w.WriteHeader(404)
+            return
+        }
+        fmt.Fprint(w, body)
+    }))
+    t.Cleanup(srv.Close)
+    return f, srv
+}
+
+var test_logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func new_test_client(t *testing.T, srv *httptest.Server, loc *time.Location) *Client {
+    auth := NewAuth(srv.URL, srv.URL, "id", "secret", filepath.Join(t.TempDir(), "wi_token.json"))
+    auth.token = &Token{Access_token: "access", Refresh_token: "refresh", Expires_in: 10800, Create_date: time.Now().Unix()}
+    return NewClient(srv.URL, auth, test_logger, loc)
+}
+
+func TestMeasureFloat(t *testing.T) {
+    tests := []struct {
+        m Measure
+        want float64
+    }{
+        {Measure{Value: 60250, Unit: -3}, 60.25},
+        {Measure{Value: 2015, Unit: -2}, 20.15},
+        {Measure{Value: 72, Unit: 0}, 72},
+        {Measure{Value: 6, Unit: 1}, 60},
+        {Measure{Value: -1, Unit: -1}, -0.1},
+    }
+    for _, tt := range tests {
+        if got := tt.m.Float(); got != tt.want {
+            t.Errorf("%+v.Float() = %v, want %v", tt.m, got, tt.want)
+        }
+    }
+
+    // NewMeasureはFloatの逆
+    for _, v := range []float64{60.25, 59.999, 0.001, 123.4} {
+        m := NewMeasure(MeasTypeWeight, v, -3)
+        if got := m.Float(); got != v {
+            t.Errorf("NewMeasure(%v).Float() = %v", v, got)
+        }
+    }
+}
+
+func TestRefreshToken(t *testing.T) {
+    f, srv := new_fake_api(t, map[string]string{
+        "/v2/oauth2?requesttoken": `{"status": 0, "body": {"userid": 12345, "access_token": "new_access", "refresh_token": "new_refresh", "expires_in": 10800, "scope": "user.info,user.metrics", "token_type": "Bearer"}}`,
+    })
+    path := filepath.Join(t.TempDir(), "wi_token.json")
+    a := NewAuth(srv.URL, srv.URL, "id", "secret", path)
+    a.token = &Token{Access_token: "old", Refresh_token: "refresh", Expires_in: 10800, Create_date: time.Now().Unix() - 10800 + 60}
+
+    // 期限切れの10分前を過ぎているのでリフレッシュする
+    err := a.RefreshToken()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(f.requests) != 1 {
+        t.Fatalf("requests = %d", len(f.requests))
+    }
+    q := f.requests[0]
+    want := map[string]string{"action": "requesttoken", "grant_type": "refresh_token", "refresh_token": "refresh", "client_id": "id", "client_secret": "secret"}
+    for k, v := range want {
+        if q.Get(k) != v {
+            t.Errorf("%s = %q, want %q", k, q.Get(k), v)
+        }
+    }
+
+    tok := a.Token()
+    if tok.Access_token != "new_access" || tok.Refresh_token != "new_refresh" || tok.User_id.String() != "12345" || !tok.HasScope("user.metrics") {
+        t.Errorf("token = %+v", tok)
+    }
+    if tok.IsTokenNeedRefresh() {
+        t.Errorf("new token needs refresh")
+    }
+
+    // リフレッシュトークンも変わるのでファイルに書き戻す
+    b := NewAuth(srv.URL, srv.URL, "id", "secret", path)
+    err = b.LoadToken()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if b.Token().Refresh_token != "new_refresh" || b.Token().Create_date != tok.Create_date {
+        t.Errorf("saved token = %+v", b.Token())
+    }
+
+    // 期限に余裕がある場合はリフレッシュしない
+    err = b.RefreshToken()
+    if err != nil || len(f.requests) != 1 {
+        t.Errorf("refreshed again: requests = %d, err = %v", len(f.requests), err)
+    }
+}
+
+func TestRefreshTokenError(t *testing.T) {
+    _, srv := new_fake_api(t, map[string]string{
+        "/v2/oauth2?requesttoken": `{"status": 503, "error": "Invalid Params: invalid refresh_token"}`,
+    })
+    path := filepath.Join(t.TempDir(), "wi_token.json")
+    a := NewAuth(srv.URL, srv.URL, "id", "secret", path)
+    a.token = &Token{Refresh_token: "expired"}
+
+    err := a.ForceRefreshToken()
+    if err == nil || !strings.Contains(err.Error(), "status 503") || !strings.Contains(err.Error(), "invalid refresh_token") {
+        t.Errorf("err = %v", err)
+    }
+    // 失敗した場合はトークンファイルを書き換えない
+    if _, err := os.Stat(path); err == nil {
+        t.Errorf("token file was written")
+    }
+    if a.Token().Refresh_token != "expired" {
+        t.Errorf("token = %+v", a.Token())
+    }
+}
+
+func TestExisting(t *testing.T) {
+    loc := time.FixedZone("JST", 9 * 60 * 60)
+    date := time.Date(2024, 1, 2, 7, 30, 0, 0, loc)
+    f, srv := new_fake_api(t, map[string]string{
+        "/measure?getmeas": fmt.Sprintf(`{"status": 0, "body": {"timezone": "Asia/Tokyo", "measuregrps": [
+            {"grpid": 1, "attrib": 0, "date": %d, "category": 1, "measures": [{"value": 60250, "type": 1, "unit": -3}, {"value": 2015, "type": 6, "unit": -2}]},
+            {"grpid": 2, "attrib": 0, "date": %d, "category": 1, "measures": [{"value": 6100, "type": 1, "unit": -2}]}
+        ]}}`, date.Unix(), date.Add(time.Hour).Unix()),
+    })
+    c := new_test_client(t, srv, loc)
+
+    // UTCの日時で渡してもWithingsのタイムゾーンの日付で取得する
+    got, err := c.Existing(date.In(time.UTC))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    q := f.requests[0]
+    start := time.Date(2024, 1, 2, 0, 0, 0, 0, loc)
+    want := map[string]string{
+        "action": "getmeas",
+        "meastypes": "1,6",
+        "category": "1",
+        "startdate": fmt.Sprint(start.Unix()),
+        "enddate": fmt.Sprint(start.AddDate(0, 0, 1).Unix() - 1),
+    }
+    for k, v := range want {
+        if q.Get(k) != v {
+            t.Errorf("%s = %q, want %q", k, q.Get(k), v)
+        }
+    }
+    if f.auth[0] != "Bearer access" {
+        t.Errorf("auth = %q", f.auth[0])
+    }
+
+    if len(got) != 2 {
+        t.Fatalf("got %d", len(got))
+    }
+    if !got[0].Date.Equal(date) || got[0].Date.Location() != loc || got[0].Weight != 60.25 || got[0].BodyFat != 20.15 || got[0].Source != "Withings" {
+        t.Errorf("got[0] = %s", &got[0])
+    }
+    if got[1].Weight != 61 || got[1].BodyFat != 0 {
+        t.Errorf("got[1] = %s", &got[1])
+    }
+}
+
+func TestWrite(t *testing.T) {
+    tests := []struct {
+        name string
+        m measurement.BodyMeasurement
+        measures []Measure
+    }{
+        {
+            name: "weight and fat",
+            m: measurement.BodyMeasurement{Weight: 60.25, BodyFat: 20.1},
+            measures: []Measure{{Value: 60250, Type: MeasTypeWeight, Unit: -3}, {Value: 2010, Type: MeasTypeFatRatio, Unit: -2}},
+        },
+        {
+            name: "weight only",
+            m: measurement.BodyMeasurement{Weight: 59.9},
+            measures: []Measure{{Value: 59900, Type: MeasTypeWeight, Unit: -3}},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            f, srv := new_fake_api(t, map[string]string{
+                "/measure?setmeas": `{"status": 0, "body": {"grpid": 123}}`,
+            })
+            c := new_test_client(t, srv, time.UTC)
+            tt.m.Date = time.Date(2024, 1, 2, 7, 30, 0, 0, time.FixedZone("JST", 9 * 60 * 60))
+
+            err := c.Write(tt.m)
+            if err != nil {
+                t.Fatal(err)
+            }
+            q := f.requests[0]
+            if q.Get("action") != "setmeas" || q.Get("category") != "1" || q.Get("date") != fmt.Sprint(tt.m.Date.Unix()) {
+                t.Errorf("request = %v", q)
+            }
+            var measures []Measure
+            err = json.Unmarshal([]byte(q.Get("measures")), &measures)
+            if err != nil {
+                t.Fatalf("measures = %q: %s", q.Get("measures"), err)
+            }
+            if fmt.Sprint(measures) != fmt.Sprint(tt.measures) {
+                t.Errorf("measures = %v, want %v", measures, tt.measures)
+            }
+        })
+    }
+}
+
+func TestStatusError(t *testing.T) {
+    _, srv := new_fake_api(t, map[string]string{
+        "/measure?getmeas": `{"status": 401, "error": "XRequestID: Not provided invalid_token: The access token provided is invalid"}`,
+        "/measure?setmeas": `{"status": 2555, "error": "An unknown error occurred"}`,
+    })
+    c := new_test_client(t, srv, time.UTC)
+
+    _, err := c.Existing(time.Now())
+    if err == nil || !strings.Contains(err.Error(), "status 401") {
+        t.Errorf("getmeas err = %v", err)
+    }
+    err = c.Write(measurement.BodyMeasurement{Date: time.Now(), Weight: 60})
+    if err == nil || !strings.Contains(err.Error(), "status 2555") {
+        t.Errorf("setmeas err = %v", err)
+    }
+
+    // 接続できない場合
+    srv.Close()
+    _, err = c.GetMeasures(time.Now(), time.Now())
+    if err == nil {
+        t.Errorf("expected error after server closed")
+    }
+}
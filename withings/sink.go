@@ -0,0 +1,46 @@
+package withings
+
+import (
+    "time"
+    "github.com/kamaboko123/tanita_to_fitbit/measurement"
+)
+
+func (c *Client) Name() string {
+    return "withings"
+}
+
+func (c *Client) Location() *time.Location {
+    return c.Timezone
+}
+
+// dateと同じ日(Withingsのタイムゾーン)の体重・体脂肪率の記録を返す (measurement.Sink)
+func (c *Client) Existing(date time.Time) ([]measurement.BodyMeasurement, error) {
+    d := date.In(c.Timezone)
+    start := time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, c.Timezone)
+    end := start.AddDate(0, 0, 1).Add(-time.Second)
+
+    resp, err := c.GetMeasures(start, end)
+    if err != nil {
+        return nil, err
+    }
+
+    var ret []measurement.BodyMeasurement
+    for _, wl := range resp.ToWeightLog(c.Timezone) {
+        ret = append(ret, wl.ToBodyMeasurement())
+    }
+    return ret, nil
+}
+
+// Withingsはunix時刻で記録するので、タイムゾーンの変換は不要
+func (c *Client) Write(m measurement.BodyMeasurement) error {
+    return c.CreateMeasure(m.Date, m.Weight, m.BodyFat)
+}
+
+func (w *WeightLog) ToBodyMeasurement() measurement.BodyMeasurement {
+    return measurement.BodyMeasurement{
+        Date: w.Date,
+        Weight: w.Weight,
+        BodyFat: w.Fat,
+        Source: "Withings",
+    }
+}